@@ -18,27 +18,134 @@ package v1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+const (
+	// CAPIDeploymentFinalizer allows the operator to tear down everything it
+	// created for a CAPIDeployment before the object is removed.
+	CAPIDeploymentFinalizer = "capideployment.capi.openshift.io"
+
+	// OwnerNamespaceLabel and OwnerNameLabel identify the CAPIDeployment that
+	// created a cluster scoped object, which cannot carry an owner reference
+	// to a namespaced CAPIDeployment.
+	OwnerNamespaceLabel = "capi.openshift.io/owner-namespace"
+	OwnerNameLabel      = "capi.openshift.io/owner-name"
+)
+
 // CAPIDeploymentSpec defines the desired state of CAPIDeployment
 type CAPIDeploymentSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ClusterAPI configures the core Cluster API manager.
+	// +optional
+	ClusterAPI ProviderSpec `json:"clusterAPI,omitempty"`
+
+	// InfrastructureProvider configures the Cluster API infrastructure
+	// provider manager.
+	// +optional
+	InfrastructureProvider ProviderSpec `json:"infrastructureProvider,omitempty"`
+
+	// FailureDomains replaces the failure domains the operator derives from
+	// the zones of the cluster's Machines.
+	// +optional
+	FailureDomains []FailureDomain `json:"failureDomains,omitempty"`
+}
+
+// FailureDomain is a zone Cluster API may place Machines in.
+type FailureDomain struct {
+	// Name of the zone, e.g. us-east-1a.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
 
-	// Foo is an example field of CAPIDeployment. Edit CAPIDeployment_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// ControlPlane is true when control plane Machines may be placed in the
+	// zone.
+	// +optional
+	ControlPlane bool `json:"controlPlane,omitempty"`
+}
+
+// ProviderSpec configures the Deployment of a Cluster API provider manager.
+type ProviderSpec struct {
+	// Name of the provider: cluster-api for the core manager, or the
+	// infrastructure provider of the cluster platform. Defaulted on creation
+	// and immutable.
+	// +kubebuilder:validation:Enum=cluster-api;aws;azure;gcp;openstack;vsphere
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Version of the provider. Defaulted to the version shipped with the
+	// operator; only versions shipped with the operator are accepted.
+	// +kubebuilder:validation:Pattern=`^v[0-9]+\.[0-9]+\.[0-9]+$`
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Image is the container image of the provider manager. When omitted the
+	// operator's default image for the provider is used.
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Replicas is the number of provider manager replicas.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=1
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// LogVerbosity is the log level passed to the provider manager with --v.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +kubebuilder:default=4
+	// +optional
+	LogVerbosity *int32 `json:"logVerbosity,omitempty"`
+
+	// ExtraArgs are additional flags passed to the provider manager, keyed by
+	// flag name without the leading dashes.
+	// +optional
+	ExtraArgs map[string]string `json:"extraArgs,omitempty"`
+
+	// FeatureGates enables or disables provider manager feature gates.
+	// +optional
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
 }
 
 // CAPIDeploymentStatus defines the observed state of CAPIDeployment
 type CAPIDeploymentStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the most recent generation observed by the operator.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions defines the current state of the CAPIDeployment.
+	// +optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+
+	// Components reports the readiness of each object managed by the operator.
+	// +optional
+	Components []ComponentStatus `json:"components,omitempty"`
+}
+
+// ComponentStatus reports the observed state of an object managed by the
+// operator.
+type ComponentStatus struct {
+	// Kind of the component object.
+	Kind string `json:"kind"`
+
+	// Name of the component object.
+	Name string `json:"name"`
+
+	// Ready is true when the component is fully available.
+	Ready bool `json:"ready"`
+
+	// Message is a human readable description of the component state.
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Available",type="string",JSONPath=".status.conditions[?(@.type==\"Available\")].status"
+// +kubebuilder:printcolumn:name="Degraded",type="string",JSONPath=".status.conditions[?(@.type==\"Degraded\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // CAPIDeployment is the Schema for the capideployments API
 type CAPIDeployment struct {
@@ -58,6 +165,16 @@ type CAPIDeploymentList struct {
 	Items           []CAPIDeployment `json:"items"`
 }
 
+// GetConditions returns the conditions of the CAPIDeployment.
+func (c *CAPIDeployment) GetConditions() clusterv1.Conditions {
+	return c.Status.Conditions
+}
+
+// SetConditions sets the conditions of the CAPIDeployment.
+func (c *CAPIDeployment) SetConditions(conditions clusterv1.Conditions) {
+	c.Status.Conditions = conditions
+}
+
 func init() {
 	SchemeBuilder.Register(&CAPIDeployment{}, &CAPIDeploymentList{})
 }
@@ -0,0 +1,276 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/docker/distribution/reference"
+	configv1 "github.com/openshift/api/config/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/version"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var capideploymentlog = logf.Log.WithName("capideployment-resource")
+
+const (
+	capiDeploymentDefaulterPath = "/mutate-capi-openshift-io-v1-capideployment"
+	capiDeploymentValidatorPath = "/validate-capi-openshift-io-v1-capideployment"
+)
+
+// SetupWebhookWithManager registers the CAPIDeployment webhooks. They check
+// CAPIDeployments against the cluster state, read through the manager's API
+// reader.
+func (r *CAPIDeployment) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	server := mgr.GetWebhookServer()
+	server.Register(capiDeploymentDefaulterPath, &webhook.Admission{Handler: &capiDeploymentDefaulter{reader: mgr.GetAPIReader()}})
+	server.Register(capiDeploymentValidatorPath, &webhook.Admission{Handler: &capiDeploymentValidator{reader: mgr.GetAPIReader()}})
+	return nil
+}
+
+// +kubebuilder:webhook:path=/mutate-capi-openshift-io-v1-capideployment,mutating=true,failurePolicy=fail,groups=capi.openshift.io,resources=capideployments,verbs=create;update,versions=v1,name=mcapideployment.kb.io
+
+// capiDeploymentDefaulter is the mutating webhook of CAPIDeployments.
+type capiDeploymentDefaulter struct {
+	reader  client.Reader
+	decoder *admission.Decoder
+}
+
+var _ admission.DecoderInjector = &capiDeploymentDefaulter{}
+
+func (d *capiDeploymentDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+func (d *capiDeploymentDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	capiDeployment := &CAPIDeployment{}
+	if err := d.decoder.Decode(req, capiDeployment); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	d.Default(ctx, capiDeployment)
+
+	marshaled, err := json.Marshal(capiDeployment)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// Default fills in the provider names and the versions shipped with the
+// operator. The infrastructure provider is left empty when the
+// Infrastructure object cannot be read, the operator then uses the provider
+// of the platform.
+func (d *capiDeploymentDefaulter) Default(ctx context.Context, capiDeployment *CAPIDeployment) {
+	capideploymentlog.Info("default", "namespace", capiDeployment.Namespace, "name", capiDeployment.Name)
+
+	if capiDeployment.Spec.InfrastructureProvider.Name == "" {
+		infra := &configv1.Infrastructure{}
+		if err := d.reader.Get(ctx, types.NamespacedName{Name: "cluster"}, infra); err != nil {
+			capideploymentlog.Error(err, "Failed to get infrastructure object")
+		} else {
+			capiDeployment.Spec.InfrastructureProvider.Name = InfrastructureProviderName(infra)
+		}
+	}
+
+	capiDeployment.Default()
+}
+
+// Default fills in the name of the core provider and the versions shipped
+// with the operator.
+func (r *CAPIDeployment) Default() {
+	if r.Spec.ClusterAPI.Name == "" {
+		r.Spec.ClusterAPI.Name = ClusterAPIProviderName
+	}
+
+	for _, provider := range []*ProviderSpec{&r.Spec.ClusterAPI, &r.Spec.InfrastructureProvider} {
+		if provider.Version == "" {
+			provider.Version = DefaultProviderVersion(provider.Name)
+		}
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-capi-openshift-io-v1-capideployment,mutating=false,failurePolicy=fail,groups=capi.openshift.io,resources=capideployments,versions=v1,name=vcapideployment.kb.io
+
+// capiDeploymentValidator is the validating webhook of CAPIDeployments.
+type capiDeploymentValidator struct {
+	reader  client.Reader
+	decoder *admission.Decoder
+}
+
+var _ admission.DecoderInjector = &capiDeploymentValidator{}
+
+func (v *capiDeploymentValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+func (v *capiDeploymentValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	capiDeployment := &CAPIDeployment{}
+	if err := v.decoder.Decode(req, capiDeployment); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var err error
+	switch req.Operation {
+	case admissionv1beta1.Create:
+		err = v.ValidateCreate(ctx, capiDeployment)
+	case admissionv1beta1.Update:
+		old := &CAPIDeployment{}
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		err = capiDeployment.ValidateUpdate(old)
+	}
+	if err == nil {
+		return admission.Allowed("")
+	}
+
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		status := apiStatus.Status()
+		return admission.Response{AdmissionResponse: admissionv1beta1.AdmissionResponse{Allowed: false, Result: &status}}
+	}
+	return admission.Denied(err.Error())
+}
+
+// ValidateCreate rejects invalid specs and a second CAPIDeployment in a
+// namespace.
+func (v *capiDeploymentValidator) ValidateCreate(ctx context.Context, capiDeployment *CAPIDeployment) error {
+	capideploymentlog.Info("validate create", "namespace", capiDeployment.Namespace, "name", capiDeployment.Name)
+
+	allErrs := capiDeployment.validateSpec()
+
+	capiDeployments := &CAPIDeploymentList{}
+	if err := v.reader.List(ctx, capiDeployments, client.InNamespace(capiDeployment.Namespace)); err != nil {
+		return apierrors.NewInternalError(fmt.Errorf("failed to list CAPIDeployments: %w", err))
+	}
+	for _, existing := range capiDeployments.Items {
+		if existing.Name != capiDeployment.Name {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("metadata", "namespace"), fmt.Sprintf("namespace %s already hosts CAPIDeployment %s", capiDeployment.Namespace, existing.Name)))
+			break
+		}
+	}
+
+	return capiDeployment.toInvalid(allErrs)
+}
+
+// ValidateUpdate rejects invalid changes to the spec, switching providers in
+// place and provider downgrades. Only the fields that changed are validated,
+// so a value that a newer operator no longer supports does not block
+// unrelated updates, and objects being deleted are always let through so
+// their finalizers can be removed.
+func (r *CAPIDeployment) ValidateUpdate(old *CAPIDeployment) error {
+	capideploymentlog.Info("validate update", "namespace", r.Namespace, "name", r.Name)
+
+	if r.DeletionTimestamp != nil || equality.Semantic.DeepEqual(old.Spec, r.Spec) {
+		return nil
+	}
+
+	allErrs := validateSpecUpdate(old.Spec, r.Spec)
+
+	specPath := field.NewPath("spec")
+	for _, p := range []struct {
+		path     *field.Path
+		old, new ProviderSpec
+	}{
+		{specPath.Child("clusterAPI"), old.Spec.ClusterAPI, r.Spec.ClusterAPI},
+		{specPath.Child("infrastructureProvider"), old.Spec.InfrastructureProvider, r.Spec.InfrastructureProvider},
+	} {
+		if p.old.Name != "" && p.new.Name != p.old.Name {
+			allErrs = append(allErrs, field.Forbidden(p.path.Child("name"), fmt.Sprintf("provider %s cannot be replaced in place", p.old.Name)))
+		}
+
+		if p.old.Version == "" || p.new.Version == "" || p.new.Version == p.old.Version {
+			continue
+		}
+		oldVersion, oldErr := version.ParseSemantic(p.old.Version)
+		newVersion, newErr := version.ParseSemantic(p.new.Version)
+		if oldErr == nil && newErr == nil && newVersion.LessThan(oldVersion) {
+			allErrs = append(allErrs, field.Forbidden(p.path.Child("version"), fmt.Sprintf("provider %s cannot be downgraded from %s", p.old.Name, p.old.Version)))
+		}
+	}
+
+	return r.toInvalid(allErrs)
+}
+
+func (r *CAPIDeployment) validateSpec() field.ErrorList {
+	return validateSpecUpdate(CAPIDeploymentSpec{}, r.Spec)
+}
+
+// validateSpecUpdate validates the fields of spec that differ from old. An
+// empty old spec validates the whole of spec.
+func validateSpecUpdate(old, spec CAPIDeploymentSpec) field.ErrorList {
+	var allErrs field.ErrorList
+
+	specPath := field.NewPath("spec")
+	clusterAPIPath := specPath.Child("clusterAPI")
+	if name := spec.ClusterAPI.Name; name != old.ClusterAPI.Name && name != "" && name != ClusterAPIProviderName {
+		allErrs = append(allErrs, field.NotSupported(clusterAPIPath.Child("name"), name, []string{ClusterAPIProviderName}))
+	}
+	allErrs = append(allErrs, validateProviderSpecUpdate(old.ClusterAPI, spec.ClusterAPI, clusterAPIPath)...)
+
+	infrastructureProviderPath := specPath.Child("infrastructureProvider")
+	if name := spec.InfrastructureProvider.Name; name != old.InfrastructureProvider.Name && name == ClusterAPIProviderName {
+		allErrs = append(allErrs, field.Invalid(infrastructureProviderPath.Child("name"), name, "must be an infrastructure provider"))
+	}
+	allErrs = append(allErrs, validateProviderSpecUpdate(old.InfrastructureProvider, spec.InfrastructureProvider, infrastructureProviderPath)...)
+
+	return allErrs
+}
+
+func validateProviderSpecUpdate(old, provider ProviderSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if provider.Image != "" && provider.Image != old.Image {
+		if _, err := reference.ParseNormalizedNamed(provider.Image); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("image"), provider.Image, err.Error()))
+		}
+	}
+
+	if provider.Version != "" && (provider.Version != old.Version || provider.Name != old.Name) {
+		if provider.Name == "" {
+			allErrs = append(allErrs, field.Required(path.Child("name"), "the provider of a version must be set"))
+		} else if !IsSupportedProviderVersion(provider.Name, provider.Version) {
+			allErrs = append(allErrs, field.NotSupported(path.Child("version"), provider.Version, supportedProviderVersions(provider.Name)))
+		}
+	}
+
+	return allErrs
+}
+
+func (r *CAPIDeployment) toInvalid(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("CAPIDeployment").GroupKind(), r.Name, allErrs)
+}
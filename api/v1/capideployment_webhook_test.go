@@ -0,0 +1,236 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sutilspointer "k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func webhookScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = AddToScheme(scheme)
+	_ = configv1.AddToScheme(scheme)
+	return scheme
+}
+
+func TestCAPIDeploymentDefault(t *testing.T) {
+	g := NewWithT(t)
+
+	capiDeployment := &CAPIDeployment{
+		Spec: CAPIDeploymentSpec{
+			InfrastructureProvider: ProviderSpec{Name: AWSProviderName},
+		},
+	}
+	capiDeployment.Default()
+
+	g.Expect(capiDeployment.Spec.ClusterAPI.Name).To(Equal(ClusterAPIProviderName))
+	g.Expect(capiDeployment.Spec.ClusterAPI.Version).To(Equal(DefaultProviderVersion(ClusterAPIProviderName)))
+	g.Expect(capiDeployment.Spec.InfrastructureProvider.Version).To(Equal(DefaultProviderVersion(AWSProviderName)))
+}
+
+func TestCAPIDeploymentDefaulter(t *testing.T) {
+	g := NewWithT(t)
+
+	infra := &configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+		Status:     configv1.InfrastructureStatus{Platform: configv1.AWSPlatformType},
+	}
+	defaulter := &capiDeploymentDefaulter{reader: fake.NewFakeClientWithScheme(webhookScheme(), infra)}
+
+	capiDeployment := &CAPIDeployment{}
+	defaulter.Default(context.Background(), capiDeployment)
+
+	g.Expect(capiDeployment.Spec.InfrastructureProvider.Name).To(Equal(AWSProviderName))
+	g.Expect(capiDeployment.Spec.InfrastructureProvider.Version).To(Equal(DefaultProviderVersion(AWSProviderName)))
+
+	// Without an Infrastructure object the provider is left to the operator.
+	defaulter = &capiDeploymentDefaulter{reader: fake.NewFakeClientWithScheme(webhookScheme())}
+	capiDeployment = &CAPIDeployment{}
+	defaulter.Default(context.Background(), capiDeployment)
+
+	g.Expect(capiDeployment.Spec.InfrastructureProvider.Name).To(BeEmpty())
+}
+
+func TestCAPIDeploymentValidate(t *testing.T) {
+	valid := func() *CAPIDeployment {
+		capiDeployment := &CAPIDeployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"},
+			Spec: CAPIDeploymentSpec{
+				InfrastructureProvider: ProviderSpec{Name: AWSProviderName},
+			},
+		}
+		capiDeployment.Default()
+		return capiDeployment
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(*CAPIDeployment)
+		wantErr bool
+	}{
+		{
+			name:   "defaulted",
+			mutate: func(*CAPIDeployment) {},
+		},
+		{
+			name: "image with digest",
+			mutate: func(c *CAPIDeployment) {
+				c.Spec.ClusterAPI.Image = "quay.io/example/capi@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+			},
+		},
+		{
+			name: "invalid image",
+			mutate: func(c *CAPIDeployment) {
+				c.Spec.InfrastructureProvider.Image = "quay.io/Example/capa:latest"
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsupported version",
+			mutate: func(c *CAPIDeployment) {
+				c.Spec.InfrastructureProvider.Version = "v9.9.9"
+			},
+			wantErr: true,
+		},
+		{
+			name: "core provider as infrastructure provider",
+			mutate: func(c *CAPIDeployment) {
+				c.Spec.InfrastructureProvider.Name = ClusterAPIProviderName
+				c.Spec.InfrastructureProvider.Version = ""
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			capiDeployment := valid()
+			tt.mutate(capiDeployment)
+
+			validator := &capiDeploymentValidator{reader: fake.NewFakeClientWithScheme(webhookScheme())}
+			err := validator.ValidateCreate(context.Background(), capiDeployment)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestCAPIDeploymentValidateUpdate(t *testing.T) {
+	g := NewWithT(t)
+
+	old := &CAPIDeployment{
+		Spec: CAPIDeploymentSpec{
+			InfrastructureProvider: ProviderSpec{Name: AWSProviderName},
+		},
+	}
+	old.Default()
+
+	updated := old.DeepCopy()
+	updated.Spec.InfrastructureProvider.Replicas = nil
+	g.Expect(updated.ValidateUpdate(old)).To(Succeed())
+
+	// Switching the infrastructure provider in place is forbidden.
+	updated = old.DeepCopy()
+	updated.Spec.InfrastructureProvider.Name = AzureProviderName
+	updated.Spec.InfrastructureProvider.Version = DefaultProviderVersion(AzureProviderName)
+	g.Expect(updated.ValidateUpdate(old)).NotTo(Succeed())
+
+	// Upgrading to a newer supported release is allowed, downgrading is not.
+	older := old.DeepCopy()
+	older.Spec.ClusterAPI.Version = "v0.3.11"
+	newer := old.DeepCopy()
+	newer.Spec.ClusterAPI.Version = "v0.3.12"
+	g.Expect(newer.ValidateUpdate(older)).To(Succeed())
+	g.Expect(older.ValidateUpdate(newer)).NotTo(Succeed())
+}
+
+func TestCAPIDeploymentValidateUpdateUnchangedFields(t *testing.T) {
+	g := NewWithT(t)
+
+	// A version the operator no longer supports, stored by an older release.
+	old := &CAPIDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"},
+		Spec: CAPIDeploymentSpec{
+			ClusterAPI:             ProviderSpec{Name: ClusterAPIProviderName, Version: "v0.3.1"},
+			InfrastructureProvider: ProviderSpec{Name: AWSProviderName},
+		},
+	}
+	g.Expect(old.validateSpec()).NotTo(BeEmpty())
+
+	// Metadata only updates, such as removing a finalizer, are let through.
+	updated := old.DeepCopy()
+	updated.Finalizers = nil
+	g.Expect(updated.ValidateUpdate(old)).To(Succeed())
+
+	// So are updates of objects being deleted.
+	deleted := old.DeepCopy()
+	deleted.DeletionTimestamp = &metav1.Time{}
+	deleted.Spec.InfrastructureProvider.Name = AzureProviderName
+	g.Expect(deleted.ValidateUpdate(old)).To(Succeed())
+
+	// Changing another field does not revalidate the stored version.
+	updated = old.DeepCopy()
+	updated.Spec.InfrastructureProvider.Replicas = k8sutilspointer.Int32Ptr(2)
+	g.Expect(updated.ValidateUpdate(old)).To(Succeed())
+
+	// The fields that changed are still validated.
+	updated = old.DeepCopy()
+	updated.Spec.InfrastructureProvider.Image = "quay.io/Example/capa:latest"
+	g.Expect(updated.ValidateUpdate(old)).NotTo(Succeed())
+
+	updated = old.DeepCopy()
+	updated.Spec.ClusterAPI.Version = "v9.9.9"
+	g.Expect(updated.ValidateUpdate(old)).NotTo(Succeed())
+}
+
+func TestCAPIDeploymentValidateCreateNamespaceConflict(t *testing.T) {
+	g := NewWithT(t)
+
+	existing := &CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "existing"}}
+	validator := &capiDeploymentValidator{reader: fake.NewFakeClientWithScheme(webhookScheme(), existing)}
+
+	capiDeployment := &CAPIDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"},
+		Spec: CAPIDeploymentSpec{
+			InfrastructureProvider: ProviderSpec{Name: AWSProviderName},
+		},
+	}
+	capiDeployment.Default()
+
+	g.Expect(validator.ValidateCreate(context.Background(), capiDeployment)).NotTo(Succeed())
+}
+
+func TestProviderImage(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(ProviderImage(ClusterAPIProviderName, "v0.3.11")).To(HaveSuffix(":v0.3.11"))
+	g.Expect(ProviderImage(ClusterAPIProviderName, "v0.3.12")).To(HaveSuffix(":v0.3.12"))
+	g.Expect(ProviderImage(ClusterAPIProviderName, "v9.9.9")).To(BeEmpty())
+}
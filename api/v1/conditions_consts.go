@@ -0,0 +1,81 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+
+const (
+	// AvailableCondition reports whether the CAPI cluster and all provider managers are ready.
+	AvailableCondition clusterv1.ConditionType = "Available"
+	// ProgressingCondition reports whether a provider manager rollout is in progress.
+	ProgressingCondition clusterv1.ConditionType = "Progressing"
+	// DegradedCondition reports whether the last reconcile of the CAPIDeployment failed.
+	DegradedCondition clusterv1.ConditionType = "Degraded"
+
+	// AsExpectedReason is used when a condition is in its expected state.
+	AsExpectedReason = "AsExpected"
+	// ReconcileFailedReason is used when reconciling the CAPIDeployment returned an error.
+	ReconcileFailedReason = "ReconcileFailed"
+	// RolloutInProgressReason is used when a provider Deployment is rolling out.
+	RolloutInProgressReason = "RolloutInProgress"
+	// DeletingReason is used while the operator tears down a CAPIDeployment.
+	DeletingReason = "Deleting"
+)
+
+const (
+	// AdmittedCondition reports whether the CAPIDeployment is the one deploying Cluster API in its namespace.
+	AdmittedCondition clusterv1.ConditionType = "Admitted"
+	// DuplicateCAPIDeploymentReason is used when an older CAPIDeployment already deploys Cluster API in the namespace.
+	DuplicateCAPIDeploymentReason = "DuplicateCAPIDeployment"
+)
+
+const (
+	// InfrastructureReadyCondition reports on the CAPI Cluster and its infrastructure cluster object.
+	InfrastructureReadyCondition clusterv1.ConditionType = "InfrastructureReady"
+	// InfrastructureNotFoundReason is used when the cluster Infrastructure object can't be read.
+	InfrastructureNotFoundReason = "InfrastructureNotFound"
+	// PlatformNotSupportedReason is used when no infrastructure provider exists for the cluster platform.
+	PlatformNotSupportedReason = "PlatformNotSupported"
+	// PlatformStatusMissingReason is used when the Infrastructure object lacks platform status the provider needs.
+	PlatformStatusMissingReason = "PlatformStatusMissing"
+	// PlatformConfigInvalidReason is used when the Infrastructure object carries malformed platform configuration, such as an invalid service endpoint.
+	PlatformConfigInvalidReason = "PlatformConfigInvalid"
+	// ClusterReconcileFailedReason is used when creating or updating the CAPI Cluster fails.
+	ClusterReconcileFailedReason = "ClusterReconcileFailed"
+	// InfrastructureClusterReconcileFailedReason is used when creating or updating the infrastructure cluster fails.
+	InfrastructureClusterReconcileFailedReason = "InfrastructureClusterReconcileFailed"
+	// WaitingForInfrastructureReason is used while the infrastructure cluster is not yet ready.
+	WaitingForInfrastructureReason = "WaitingForInfrastructure"
+)
+
+const (
+	// CredentialsReadyCondition reports whether the cloud credentials of the infrastructure provider manager are in place.
+	CredentialsReadyCondition clusterv1.ConditionType = "CredentialsReady"
+	// WaitingForCredentialsReason is used while the cloud credentials the provider manager needs do not exist yet.
+	WaitingForCredentialsReason = "WaitingForCredentials"
+	// CredentialsReconcileFailedReason is used when provisioning the provider manager credentials fails.
+	CredentialsReconcileFailedReason = "CredentialsReconcileFailed"
+)
+
+const (
+	// ProvidersReadyCondition reports on the availability of the provider manager Deployments.
+	ProvidersReadyCondition clusterv1.ConditionType = "ProvidersReady"
+	// ProviderReconcileFailedReason is used when creating or updating provider components fails.
+	ProviderReconcileFailedReason = "ProviderReconcileFailed"
+	// ProvidersNotReadyReason is used when at least one provider Deployment is not available.
+	ProvidersNotReadyReason = "ProvidersNotReady"
+)
@@ -0,0 +1,123 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	configv1 "github.com/openshift/api/config/v1"
+)
+
+// Provider names used in ProviderSpec.Name.
+const (
+	ClusterAPIProviderName = "cluster-api"
+	AWSProviderName        = "aws"
+	AzureProviderName      = "azure"
+	GCPProviderName        = "gcp"
+	OpenStackProviderName  = "openstack"
+	VSphereProviderName    = "vsphere"
+)
+
+// providerRelease is a version of a provider shipped with the operator and
+// the image it runs.
+type providerRelease struct {
+	version string
+	image   string
+}
+
+// providerReleases lists the provider versions shipped with this release of
+// the operator, newest first. The first version of each provider is its
+// default.
+var providerReleases = map[string][]providerRelease{
+	ClusterAPIProviderName: {
+		{"v0.3.12", "us.gcr.io/k8s-artifacts-prod/cluster-api/cluster-api-controller:v0.3.12"},
+		{"v0.3.11", "us.gcr.io/k8s-artifacts-prod/cluster-api/cluster-api-controller:v0.3.11"},
+	},
+	AWSProviderName: {
+		{"v0.6.5", "quay.io/ademicev/cluster-api-aws-controller-amd64:dev"},
+	},
+	AzureProviderName: {
+		{"v0.4.15", "us.gcr.io/k8s-artifacts-prod/cluster-api-azure/cluster-api-azure-controller:v0.4.15"},
+		{"v0.4.14", "us.gcr.io/k8s-artifacts-prod/cluster-api-azure/cluster-api-azure-controller:v0.4.14"},
+	},
+	GCPProviderName: {
+		{"v0.3.1", "us.gcr.io/k8s-artifacts-prod/cluster-api-gcp/cluster-api-gcp-controller:v0.3.1"},
+		{"v0.3.0", "us.gcr.io/k8s-artifacts-prod/cluster-api-gcp/cluster-api-gcp-controller:v0.3.0"},
+	},
+	OpenStackProviderName: {
+		{"v0.3.4", "us.gcr.io/k8s-artifacts-prod/capi-openstack/capi-openstack-controller:v0.3.4"},
+		{"v0.3.3", "us.gcr.io/k8s-artifacts-prod/capi-openstack/capi-openstack-controller:v0.3.3"},
+	},
+	VSphereProviderName: {
+		{"v0.7.10", "gcr.io/cluster-api-provider-vsphere/release/manager:v0.7.10"},
+		{"v0.7.9", "gcr.io/cluster-api-provider-vsphere/release/manager:v0.7.9"},
+	},
+}
+
+// platformProviders maps cluster platforms to their infrastructure provider.
+var platformProviders = map[configv1.PlatformType]string{
+	configv1.AWSPlatformType:       AWSProviderName,
+	configv1.AzurePlatformType:     AzureProviderName,
+	configv1.GCPPlatformType:       GCPProviderName,
+	configv1.OpenStackPlatformType: OpenStackProviderName,
+	configv1.VSpherePlatformType:   VSphereProviderName,
+}
+
+// DefaultProviderVersion returns the default version of a provider, or an
+// empty string for an unknown provider.
+func DefaultProviderVersion(name string) string {
+	releases := providerReleases[name]
+	if len(releases) == 0 {
+		return ""
+	}
+	return releases[0].version
+}
+
+// IsSupportedProviderVersion reports whether a version of a provider ships
+// with the operator.
+func IsSupportedProviderVersion(name, version string) bool {
+	return ProviderImage(name, version) != ""
+}
+
+// ProviderImage returns the image of a version of a provider, or an empty
+// string if the version does not ship with the operator.
+func ProviderImage(name, version string) string {
+	for _, release := range providerReleases[name] {
+		if release.version == version {
+			return release.image
+		}
+	}
+	return ""
+}
+
+// supportedProviderVersions returns the versions of a provider that ship
+// with the operator.
+func supportedProviderVersions(name string) []string {
+	versions := make([]string, 0, len(providerReleases[name]))
+	for _, release := range providerReleases[name] {
+		versions = append(versions, release.version)
+	}
+	return versions
+}
+
+// InfrastructureProviderName returns the name of the infrastructure provider
+// of the cluster platform, or an empty string if the platform has none.
+func InfrastructureProviderName(infra *configv1.Infrastructure) string {
+	platform := infra.Status.Platform
+	if infra.Status.PlatformStatus != nil && infra.Status.PlatformStatus.Type != "" {
+		platform = infra.Status.PlatformStatus.Type
+	}
+	return platformProviders[platform]
+}
@@ -0,0 +1,201 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// awsCredentialsSecret holds the AWS keys the installer created for the
+// cluster.
+var awsCredentialsSecret = types.NamespacedName{Namespace: cloudCredentialsNamespace, Name: "aws-creds"}
+
+const (
+	// operatorAWSCredentialsSecretName is the secret, in the operator's
+	// namespace, holding the IAM role the operator discovers the cluster's
+	// infrastructure with when the cluster uses short-lived credentials.
+	operatorAWSCredentialsSecretName = "cluster-api-operator-aws-credentials"
+
+	// operatorTokenFile is the projected service account token the operator
+	// assumes its IAM role with.
+	operatorTokenFile = "/var/run/secrets/openshift/serviceaccount/token"
+)
+
+// operatorIAMPolicy is the IAM policy of the operator, which only looks up
+// the infrastructure the installer created.
+var operatorIAMPolicy = []awsIAMStatement{
+	{
+		actions: []string{
+			"ec2:DescribeSecurityGroups",
+			"ec2:DescribeSubnets",
+			"elasticloadbalancing:DescribeLoadBalancers",
+		},
+		resource: "*",
+	},
+}
+
+// awsCredentials are either static keys or an IAM role assumed with a web
+// identity token.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+
+	RoleARN              string
+	WebIdentityTokenFile string
+}
+
+// awsClient is the part of the EC2 and ELBv2 APIs used to discover the
+// installer-provisioned infrastructure.
+type awsClient interface {
+	DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error)
+	DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error)
+	DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error)
+}
+
+// awsClientBuilder returns an awsClient for a region, using the custom
+// service endpoints where there are any.
+type awsClientBuilder func(creds *awsCredentials, region string, serviceEndpoints []awsServiceEndpoint) (awsClient, error)
+
+// awsClients implements awsClient on top of the SDK service clients.
+type awsClients struct {
+	ec2   ec2iface.EC2API
+	elbv2 elbv2iface.ELBV2API
+}
+
+var _ awsClient = &awsClients{}
+
+func (a *awsClients) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	return a.ec2.DescribeSubnets(input)
+}
+
+func (a *awsClients) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	return a.ec2.DescribeSecurityGroups(input)
+}
+
+func (a *awsClients) DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error) {
+	return a.elbv2.DescribeLoadBalancers(input)
+}
+
+// operatorCredentialsRequest returns the CredentialsRequest for the
+// operator's own AWS credentials.
+func operatorCredentialsRequest() *unstructured.Unstructured {
+	credentialsRequest := &unstructured.Unstructured{}
+	credentialsRequest.SetAPIVersion(credentialsRequestAPIVersion)
+	credentialsRequest.SetKind("CredentialsRequest")
+	credentialsRequest.SetNamespace(cloudCredentialOperatorNamespace)
+	credentialsRequest.SetName("openshift-cluster-api-operator-aws")
+	return credentialsRequest
+}
+
+// getAWSCredentials returns the credentials the operator calls AWS with. With
+// short-lived credentials there are no keys in kube-system, so the operator
+// requests a role of its own and assumes it with its projected service
+// account token. The role is written by ccoctl to the secret named in the
+// request.
+func getAWSCredentials(ctx context.Context, c *eventingClient, infra *clusterInfrastructure, operatorNamespace, operatorServiceAccount string) (*awsCredentials, error) {
+	if !infra.shortLivedCredentials() {
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, awsCredentialsSecret, secret); err != nil {
+			return nil, fmt.Errorf("failed to get credentials secret %s: %w", awsCredentialsSecret, err)
+		}
+
+		creds := &awsCredentials{
+			AccessKeyID:     string(secret.Data["aws_access_key_id"]),
+			SecretAccessKey: string(secret.Data["aws_secret_access_key"]),
+		}
+		if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			return nil, fmt.Errorf("credentials secret %s has no AWS access key", awsCredentialsSecret)
+		}
+		return creds, nil
+	}
+
+	if operatorNamespace == "" || operatorServiceAccount == "" {
+		return nil, errors.New("the operator namespace and service account are needed for short-lived credentials")
+	}
+
+	source := types.NamespacedName{Namespace: operatorNamespace, Name: operatorAWSCredentialsSecretName}
+	credentialsRequest := operatorCredentialsRequest()
+	_, err := c.createOrUpdate(ctx, credentialsRequest, func() error {
+		return reconcileAWSCredentialsRequest(credentialsRequest, source, operatorIAMPolicy, []string{operatorServiceAccount})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile credentials request %s: %w", credentialsRequest.GetName(), err)
+	}
+
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, source, secret); err != nil {
+		return nil, fmt.Errorf("failed to get credentials secret %s: %w", source, err)
+	}
+	roleARN := awsRoleARN(secret.Data)
+	if roleARN == "" {
+		return nil, fmt.Errorf("credentials secret %s has no role_arn", source)
+	}
+	return &awsCredentials{RoleARN: roleARN, WebIdentityTokenFile: operatorTokenFile}, nil
+}
+
+// newAWSClient builds an awsClient signed with creds.
+func newAWSClient(creds *awsCredentials, region string, serviceEndpoints []awsServiceEndpoint) (awsClient, error) {
+	resolver := func(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		for _, e := range serviceEndpoints {
+			if e.ServiceID == service {
+				return endpoints.ResolvedEndpoint{URL: e.URL, SigningRegion: e.SigningRegion}, nil
+			}
+		}
+		return endpoints.DefaultResolver().EndpointFor(service, region, optFns...)
+	}
+
+	config := &aws.Config{
+		Region:           aws.String(region),
+		EndpointResolver: endpoints.ResolverFunc(resolver),
+	}
+	if creds.RoleARN == "" {
+		config.Credentials = credentials.NewStaticCredentials(creds.AccessKeyID, creds.SecretAccessKey, "")
+	} else {
+		// The role is assumed through STS, which goes through the same
+		// endpoint resolver.
+		stsSession, err := session.NewSession(config.Copy())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AWS session: %w", err)
+		}
+		config.Credentials = stscreds.NewWebIdentityCredentials(stsSession, creds.RoleARN, "", creds.WebIdentityTokenFile)
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+
+	return &awsClients{
+		ec2:   ec2.New(sess),
+		elbv2: elbv2.New(sess),
+	}, nil
+}
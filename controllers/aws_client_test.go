@@ -0,0 +1,70 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/api/v1alpha3"
+)
+
+func TestGetAWSCredentialsShortLived(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	infra := testAWSInfrastructure("us-east-1", nil)
+	infra.CredentialsMode = credentialsModeManual
+	infra.ServiceAccountIssuer = "https://oidc.example.com"
+
+	// Only the role written by ccoctl exists, no keys in kube-system.
+	recorder := record.NewFakeRecorder(10)
+	c := &eventingClient{Client: newFakeClient(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api-operator", Name: operatorAWSCredentialsSecretName},
+		Data: map[string][]byte{
+			"credentials": []byte("[default]\nrole_arn = arn:aws:iam::123456789012:role/operator\nweb_identity_token_file = /var/run/secrets/openshift/serviceaccount/token\n"),
+		},
+	}), recorder: recorder, scheme: testScheme, owner: &infrav1.AWSCluster{}}
+
+	creds, err := getAWSCredentials(ctx, c, infra, "openshift-cluster-api-operator", "controller-manager")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(creds).To(Equal(&awsCredentials{
+		RoleARN:              "arn:aws:iam::123456789012:role/operator",
+		WebIdentityTokenFile: operatorTokenFile,
+	}))
+
+	credentialsRequest := operatorCredentialsRequest()
+	g.Expect(c.Get(ctx, types.NamespacedName{Namespace: credentialsRequest.GetNamespace(), Name: credentialsRequest.GetName()}, credentialsRequest)).To(Succeed())
+	serviceAccountNames, _, _ := unstructured.NestedStringSlice(credentialsRequest.Object, "spec", "serviceAccountNames")
+	g.Expect(serviceAccountNames).To(Equal([]string{"controller-manager"}))
+	secretName, _, _ := unstructured.NestedString(credentialsRequest.Object, "spec", "secretRef", "name")
+	g.Expect(secretName).To(Equal(operatorAWSCredentialsSecretName))
+	g.Expect(recorder.Events).To(Receive(Equal("Normal Created Created CredentialsRequest " + credentialsRequest.GetName())))
+
+	_, err = newAWSClient(creds, "us-east-1", nil)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// Without the operator's service account the role cannot be assumed.
+	_, err = getAWSCredentials(ctx, c, infra, "openshift-cluster-api-operator", "")
+	g.Expect(err).To(HaveOccurred())
+}
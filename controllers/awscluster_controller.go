@@ -18,39 +18,148 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"sort"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
 	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	infrav1 "sigs.k8s.io/cluster-api-provider-aws/api/v1alpha3"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/conditions"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // AWSClusterReconciler reconciles a AWSCluster object
 type AWSClusterReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// APIReader reads secrets, which are not cached cluster wide.
+	APIReader client.Reader
+
+	// OperatorNamespace and OperatorServiceAccount identify the operator's
+	// own pod, which assumes an IAM role when the cluster uses short-lived
+	// credentials.
+	OperatorNamespace      string
+	OperatorServiceAccount string
+
+	// awsClientBuilder defaults to newAWSClient.
+	awsClientBuilder awsClientBuilder
+}
+
+// awsInfrastructure is the installer-provisioned infrastructure of the
+// cluster, as found through the cluster's ownership tag.
+type awsInfrastructure struct {
+	VPCID          string
+	Subnets        infrav1.Subnets
+	SecurityGroups map[infrav1.SecurityGroupRole]infrav1.SecurityGroup
+	APIServerELB   infrav1.ClassicELB
 }
 
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=awsclusters,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=awsclusters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=config.openshift.io,resources=infrastructures,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=capi.openshift.io,resources=capideployments,verbs=get;list;watch
+
 func (r *AWSClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
 	log := r.Log.WithValues("AWSCluster", req.NamespacedName)
 
 	awsCluster := &infrav1.AWSCluster{}
 	err := r.Get(ctx, req.NamespacedName, awsCluster)
+	if err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// The AWSCluster watch already drops other AWSClusters, but a
+	// CAPIDeployment may share its name with one, and the annotation or owner
+	// may have been removed in the meantime.
+	if !isOperatorAWSCluster(awsCluster) || !awsCluster.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	infra, err := getClusterInfrastructure(ctx, r.Client)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get infrastructure object: %w", err)
+	}
+	infraID := infra.Status.InfrastructureName
+	if infraID == "" {
+		return reconcile.Result{}, fmt.Errorf("infrastructure %q has no infrastructure name", infra.Name)
+	}
+
+	buildAWSClient := r.awsClientBuilder
+	if buildAWSClient == nil {
+		buildAWSClient = newAWSClient
+	}
+	serviceEndpoints, err := getAWSServiceEndpoints(infra)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	c := &eventingClient{Client: uncachedSecretsClient(r.Client, r.APIReader), recorder: r.Recorder, scheme: r.Scheme, owner: awsCluster}
+	creds, err := getAWSCredentials(ctx, c, infra, r.OperatorNamespace, r.OperatorServiceAccount)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	awsClient, err := buildAWSClient(creds, awsCluster.Spec.Region, serviceEndpoints)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
 
-	log.Info("Patching AWSCluster status")
+	log.Info("Discovering cluster infrastructure", "infraID", infraID)
+
+	discovered, condition, reason, err := discoverAWSInfrastructure(awsClient, infraID)
+	if err != nil {
+		if patchErr := r.patchStatus(ctx, awsCluster, func() {
+			conditions.MarkFalse(awsCluster, condition, reason, clusterv1.ConditionSeverityError, "%v", err)
+		}); patchErr != nil {
+			log.Error(patchErr, "Failed to patch AWSCluster status")
+		}
+		return reconcile.Result{}, err
+	}
+
+	failureDomains, err := r.awsFailureDomains(ctx, awsCluster, discovered)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
 
 	clusterToBePatched := client.MergeFrom(awsCluster.DeepCopy())
+	setAWSClusterNetworkSpec(awsCluster, discovered)
+	err = r.Patch(ctx, awsCluster, clusterToBePatched)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to patch AWSCluster: %w", err)
+	}
 
-	awsCluster.Status.Ready = true
+	log.Info("Patching AWSCluster status")
 
-	err = r.Status().Patch(ctx, awsCluster, clusterToBePatched)
+	err = r.patchStatus(ctx, awsCluster, func() {
+		setAWSClusterNetworkStatus(awsCluster, discovered)
+		awsCluster.Status.FailureDomains = failureDomains
+		conditions.MarkTrue(awsCluster, infrav1.VpcReadyCondition)
+		conditions.MarkTrue(awsCluster, infrav1.SubnetsReadyCondition)
+		conditions.MarkTrue(awsCluster, infrav1.ClusterSecurityGroupsReadyCondition)
+		conditions.MarkTrue(awsCluster, infrav1.LoadBalancerReadyCondition)
+		awsCluster.Status.Ready = true
+	})
 	if err != nil {
 		return reconcile.Result{}, err
 	}
@@ -58,8 +167,350 @@ func (r *AWSClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 	return ctrl.Result{}, nil
 }
 
+// patchStatus applies mutate to the AWSCluster status and patches it.
+func (r *AWSClusterReconciler) patchStatus(ctx context.Context, awsCluster *infrav1.AWSCluster, mutate func()) error {
+	clusterToBePatched := client.MergeFrom(awsCluster.DeepCopy())
+	mutate()
+	if err := r.Status().Patch(ctx, awsCluster, clusterToBePatched); err != nil {
+		return fmt.Errorf("failed to patch AWSCluster status: %w", err)
+	}
+	return nil
+}
+
+// awsFailureDomains returns the failure domains set on the CAPIDeployment
+// the AWSCluster was created for, which shares its name. Without an override
+// they are derived from the zones of the cluster's Machines, or from the
+// subnets when there are no Machines yet.
+func (r *AWSClusterReconciler) awsFailureDomains(ctx context.Context, awsCluster *infrav1.AWSCluster, discovered *awsInfrastructure) (clusterv1.FailureDomains, error) {
+	capiDeployment := &operatorv1.CAPIDeployment{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: awsCluster.Namespace, Name: awsCluster.Name}, capiDeployment)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, fmt.Errorf("failed to get CAPIDeployment: %w", err)
+	}
+	if err == nil && len(capiDeployment.Spec.FailureDomains) > 0 {
+		return specFailureDomains(capiDeployment.Spec.FailureDomains), nil
+	}
+
+	failureDomains, err := listMachineFailureDomains(ctx, r.Client)
+	if err != nil {
+		return nil, err
+	}
+	if len(failureDomains) > 0 {
+		return failureDomains, nil
+	}
+
+	for _, subnet := range discovered.Subnets {
+		failureDomains[subnet.AvailabilityZone] = clusterv1.FailureDomainSpec{ControlPlane: true}
+	}
+	return failureDomains, nil
+}
+
+// setAWSClusterNetworkSpec points the AWSCluster at the existing VPC and
+// subnets. Leaving the tags off keeps them unmanaged for CAPA.
+func setAWSClusterNetworkSpec(awsCluster *infrav1.AWSCluster, discovered *awsInfrastructure) {
+	awsCluster.Spec.NetworkSpec.VPC.ID = discovered.VPCID
+	awsCluster.Spec.NetworkSpec.Subnets = discovered.Subnets
+}
+
+func setAWSClusterNetworkStatus(awsCluster *infrav1.AWSCluster, discovered *awsInfrastructure) {
+	awsCluster.Status.Network.SecurityGroups = discovered.SecurityGroups
+	awsCluster.Status.Network.APIServerELB = discovered.APIServerELB
+}
+
+// discoverAWSInfrastructure finds the VPC, subnets, security groups and API
+// load balancer the installer created for infraID. On failure it also
+// returns the AWSCluster condition and reason to report.
+func discoverAWSInfrastructure(awsClient awsClient, infraID string) (*awsInfrastructure, clusterv1.ConditionType, string, error) {
+	subnets, vpcID, err := discoverAWSSubnets(awsClient, infraID)
+	if err != nil {
+		return nil, infrav1.SubnetsReadyCondition, infrav1.SubnetsReconciliationFailedReason, err
+	}
+
+	securityGroups, err := discoverAWSSecurityGroups(awsClient, infraID, vpcID)
+	if err != nil {
+		return nil, infrav1.ClusterSecurityGroupsReadyCondition, infrav1.ClusterSecurityGroupReconciliationFailedReason, err
+	}
+
+	apiServerELB, err := discoverAWSAPIServerLoadBalancer(awsClient, infraID)
+	if err != nil {
+		return nil, infrav1.LoadBalancerReadyCondition, infrav1.LoadBalancerFailedReason, err
+	}
+
+	return &awsInfrastructure{
+		VPCID:          vpcID,
+		Subnets:        subnets,
+		SecurityGroups: securityGroups,
+		APIServerELB:   *apiServerELB,
+	}, "", "", nil
+}
+
+// clusterTagFilter matches resources carrying the cluster's ownership tag,
+// whether owned or shared.
+func clusterTagFilter(infraID string) *ec2.Filter {
+	return &ec2.Filter{
+		Name:   aws.String("tag-key"),
+		Values: aws.StringSlice([]string{infrav1.NameKubernetesAWSCloudProviderPrefix + infraID}),
+	}
+}
+
+// discoverAWSSubnets returns the cluster's subnets, sorted by ID, and the VPC
+// they belong to. Subnets tagged for public load balancers are public.
+func discoverAWSSubnets(awsClient awsClient, infraID string) (infrav1.Subnets, string, error) {
+	out, err := awsClient.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		Filters: []*ec2.Filter{clusterTagFilter(infraID)},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to describe subnets: %w", err)
+	}
+	if len(out.Subnets) == 0 {
+		return nil, "", fmt.Errorf("no subnets tagged for cluster %q", infraID)
+	}
+
+	vpcID := ""
+	subnets := infrav1.Subnets{}
+	for _, subnet := range out.Subnets {
+		if vpcID == "" {
+			vpcID = aws.StringValue(subnet.VpcId)
+		} else if vpcID != aws.StringValue(subnet.VpcId) {
+			return nil, "", fmt.Errorf("subnets tagged for cluster %q span VPCs %s and %s", infraID, vpcID, aws.StringValue(subnet.VpcId))
+		}
+
+		public := false
+		for _, tag := range subnet.Tags {
+			if aws.StringValue(tag.Key) == "kubernetes.io/role/elb" {
+				public = true
+			}
+		}
+
+		subnets = append(subnets, &infrav1.SubnetSpec{
+			ID:               aws.StringValue(subnet.SubnetId),
+			CidrBlock:        aws.StringValue(subnet.CidrBlock),
+			AvailabilityZone: aws.StringValue(subnet.AvailabilityZone),
+			IsPublic:         public,
+		})
+	}
+
+	sort.Slice(subnets, func(i, j int) bool { return subnets[i].ID < subnets[j].ID })
+
+	return subnets, vpcID, nil
+}
+
+// discoverAWSSecurityGroups maps the installer's master and worker security
+// groups to the CAPA control plane and node roles.
+func discoverAWSSecurityGroups(awsClient awsClient, infraID, vpcID string) (map[infrav1.SecurityGroupRole]infrav1.SecurityGroup, error) {
+	out, err := awsClient.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			clusterTagFilter(infraID),
+			{
+				Name:   aws.String("vpc-id"),
+				Values: aws.StringSlice([]string{vpcID}),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe security groups: %w", err)
+	}
+
+	roles := map[string]infrav1.SecurityGroupRole{
+		infraID + "-master-sg": infrav1.SecurityGroupControlPlane,
+		infraID + "-worker-sg": infrav1.SecurityGroupNode,
+	}
+
+	securityGroups := map[infrav1.SecurityGroupRole]infrav1.SecurityGroup{}
+	for _, sg := range out.SecurityGroups {
+		name := aws.StringValue(sg.GroupName)
+		for _, tag := range sg.Tags {
+			if aws.StringValue(tag.Key) == "Name" {
+				name = aws.StringValue(tag.Value)
+			}
+		}
+
+		if role, ok := roles[name]; ok {
+			securityGroups[role] = infrav1.SecurityGroup{
+				ID:   aws.StringValue(sg.GroupId),
+				Name: name,
+			}
+		}
+	}
+
+	for name, role := range roles {
+		if _, ok := securityGroups[role]; !ok {
+			return nil, fmt.Errorf("security group %s not found in VPC %s", name, vpcID)
+		}
+	}
+
+	return securityGroups, nil
+}
+
+// discoverAWSAPIServerLoadBalancer returns the external API load balancer,
+// or the internal one for private clusters.
+func discoverAWSAPIServerLoadBalancer(awsClient awsClient, infraID string) (*infrav1.ClassicELB, error) {
+	for _, name := range []string{infraID + "-ext", infraID + "-int"} {
+		out, err := awsClient.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{
+			Names: aws.StringSlice([]string{name}),
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == elbv2.ErrCodeLoadBalancerNotFoundException {
+				continue
+			}
+			return nil, fmt.Errorf("failed to describe load balancer %s: %w", name, err)
+		}
+		if len(out.LoadBalancers) == 0 {
+			continue
+		}
+
+		lb := out.LoadBalancers[0]
+		elb := &infrav1.ClassicELB{
+			Name:    aws.StringValue(lb.LoadBalancerName),
+			DNSName: aws.StringValue(lb.DNSName),
+			Scheme:  infrav1.ClassicELBSchemeInternal,
+		}
+		if aws.StringValue(lb.Scheme) == elbv2.LoadBalancerSchemeEnumInternetFacing {
+			elb.Scheme = infrav1.ClassicELBSchemeInternetFacing
+		}
+		for _, az := range lb.AvailabilityZones {
+			elb.AvailabilityZones = append(elb.AvailabilityZones, aws.StringValue(az.ZoneName))
+			elb.SubnetIDs = append(elb.SubnetIDs, aws.StringValue(az.SubnetId))
+		}
+		for _, sg := range lb.SecurityGroups {
+			elb.SecurityGroupIDs = append(elb.SecurityGroupIDs, aws.StringValue(sg))
+		}
+
+		return elb, nil
+	}
+
+	return nil, fmt.Errorf("no API load balancer found for cluster %q", infraID)
+}
+
+// isOperatorAWSCluster reports whether an AWSCluster belongs to this
+// operator: it carries the operator's managed-by annotation or is owned by a
+// CAPIDeployment. AWSClusters of workload clusters managed by CAPA users or
+// other tools are left alone.
+func isOperatorAWSCluster(obj metav1.Object) bool {
+	if obj.GetAnnotations()[managedByAnnotation] == managedByValue {
+		return true
+	}
+
+	for _, ref := range obj.GetOwnerReferences() {
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			continue
+		}
+		if gv.Group == operatorv1.GroupVersion.Group && ref.Kind == "CAPIDeployment" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// operatorAWSClusterPredicate filters events down to the AWSClusters
+// belonging to this operator.
+func operatorAWSClusterPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return isOperatorAWSCluster(e.Meta)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return isOperatorAWSCluster(e.MetaNew)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return isOperatorAWSCluster(e.Meta)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return isOperatorAWSCluster(e.Meta)
+		},
+	}
+}
+
+// capiDeploymentToAWSCluster requeues the AWSCluster created for a
+// CAPIDeployment, which shares its name, when its failure domains may have
+// been overridden.
+func (r *AWSClusterReconciler) capiDeploymentToAWSCluster(o handler.MapObject) []reconcile.Request {
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Namespace: o.Meta.GetNamespace(), Name: o.Meta.GetName()},
+	}}
+}
+
+// machineToAWSClusters requeues every AWSCluster created by the operator
+// when a Machine changes, as their failure domains follow the zones of the
+// Machines.
+func (r *AWSClusterReconciler) machineToAWSClusters(o handler.MapObject) []reconcile.Request {
+	awsClusters := &infrav1.AWSClusterList{}
+	if err := r.Client.List(context.Background(), awsClusters); err != nil {
+		r.Log.Error(err, "Failed to list AWSClusters")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range awsClusters.Items {
+		awsCluster := &awsClusters.Items[i]
+		if !isOperatorAWSCluster(awsCluster) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: awsCluster.Namespace, Name: awsCluster.Name},
+		})
+	}
+	return requests
+}
+
+// machineAPIPredicate filters out Machines outside of the Machine API
+// namespace, and updates that leave the failure domain of a Machine alone.
+func machineAPIPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return e.Meta.GetNamespace() == machineAPINamespace
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.MetaNew.GetNamespace() != machineAPINamespace {
+				return false
+			}
+			oldMachine, oldOK := e.ObjectOld.(*unstructured.Unstructured)
+			newMachine, newOK := e.ObjectNew.(*unstructured.Unstructured)
+			if !oldOK || !newOK {
+				return true
+			}
+			oldZone, oldControlPlane := machineFailureDomain(oldMachine)
+			newZone, newControlPlane := machineFailureDomain(newMachine)
+			return oldZone != newZone || oldControlPlane != newControlPlane
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return e.Meta.GetNamespace() == machineAPINamespace
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return e.Meta.GetNamespace() == machineAPINamespace
+		},
+	}
+}
+
+// SetupWithManager filters the AWSCluster events only: the builder's event
+// filter would also drop the CAPIDeployments and Machines the failure domains
+// are derived from.
 func (r *AWSClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&infrav1.AWSCluster{}).
-		Complete(r)
+	c, err := controller.New("awscluster", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	if err := c.Watch(&source.Kind{Type: &infrav1.AWSCluster{}}, &handler.EnqueueRequestForObject{}, operatorAWSClusterPredicate()); err != nil {
+		return err
+	}
+
+	if err := c.Watch(
+		&source.Kind{Type: &operatorv1.CAPIDeployment{}},
+		&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.capiDeploymentToAWSCluster)},
+		// Status updates cannot change the failure domains.
+		predicate.GenerationChangedPredicate{},
+	); err != nil {
+		return err
+	}
+
+	machine := &unstructured.Unstructured{}
+	machine.SetGroupVersionKind(machineGVK)
+	return c.Watch(
+		&source.Kind{Type: machine},
+		&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.machineToAWSClusters)},
+		machineAPIPredicate(),
+	)
 }
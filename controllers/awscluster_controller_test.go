@@ -0,0 +1,244 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/api/v1alpha3"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fakeAWSClient serves canned resources. Filters other than the load
+// balancer name are not evaluated.
+type fakeAWSClient struct {
+	subnets        []*ec2.Subnet
+	securityGroups []*ec2.SecurityGroup
+	loadBalancers  []*elbv2.LoadBalancer
+}
+
+var _ awsClient = &fakeAWSClient{}
+
+func (f *fakeAWSClient) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	return &ec2.DescribeSubnetsOutput{Subnets: f.subnets}, nil
+}
+
+func (f *fakeAWSClient) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: f.securityGroups}, nil
+}
+
+func (f *fakeAWSClient) DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error) {
+	out := &elbv2.DescribeLoadBalancersOutput{}
+	for _, lb := range f.loadBalancers {
+		for _, name := range input.Names {
+			if aws.StringValue(lb.LoadBalancerName) == aws.StringValue(name) {
+				out.LoadBalancers = append(out.LoadBalancers, lb)
+			}
+		}
+	}
+	if len(out.LoadBalancers) == 0 {
+		return nil, awserr.New(elbv2.ErrCodeLoadBalancerNotFoundException, "not found", nil)
+	}
+	return out, nil
+}
+
+func fakeSubnet(id, az string, public bool) *ec2.Subnet {
+	subnet := &ec2.Subnet{
+		SubnetId:         aws.String(id),
+		VpcId:            aws.String("vpc-1"),
+		CidrBlock:        aws.String("10.0.0.0/19"),
+		AvailabilityZone: aws.String(az),
+		Tags:             []*ec2.Tag{{Key: aws.String("kubernetes.io/cluster/test-abcde"), Value: aws.String("owned")}},
+	}
+	if public {
+		subnet.Tags = append(subnet.Tags, &ec2.Tag{Key: aws.String("kubernetes.io/role/elb"), Value: aws.String("")})
+	}
+	return subnet
+}
+
+func fakeSecurityGroup(id, name string) *ec2.SecurityGroup {
+	return &ec2.SecurityGroup{
+		GroupId:   aws.String(id),
+		GroupName: aws.String("terraform-" + id),
+		Tags:      []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(name)}},
+	}
+}
+
+func installerAWSClient() *fakeAWSClient {
+	return &fakeAWSClient{
+		subnets: []*ec2.Subnet{
+			fakeSubnet("subnet-b", "us-east-1b", false),
+			fakeSubnet("subnet-a", "us-east-1a", true),
+		},
+		securityGroups: []*ec2.SecurityGroup{
+			fakeSecurityGroup("sg-1", "test-abcde-master-sg"),
+			fakeSecurityGroup("sg-2", "test-abcde-worker-sg"),
+			fakeSecurityGroup("sg-3", "unrelated"),
+		},
+		loadBalancers: []*elbv2.LoadBalancer{
+			{
+				LoadBalancerName: aws.String("test-abcde-int"),
+				DNSName:          aws.String("test-abcde-int.elb.amazonaws.com"),
+				Scheme:           aws.String(elbv2.LoadBalancerSchemeEnumInternal),
+				AvailabilityZones: []*elbv2.AvailabilityZone{
+					{ZoneName: aws.String("us-east-1b"), SubnetId: aws.String("subnet-b")},
+				},
+			},
+		},
+	}
+}
+
+func TestDiscoverAWSInfrastructure(t *testing.T) {
+	g := NewWithT(t)
+
+	discovered, _, _, err := discoverAWSInfrastructure(installerAWSClient(), "test-abcde")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(discovered.VPCID).To(Equal("vpc-1"))
+	g.Expect(discovered.Subnets.IDs()).To(Equal([]string{"subnet-a", "subnet-b"}))
+	g.Expect(discovered.Subnets.FindByID("subnet-a").IsPublic).To(BeTrue())
+	g.Expect(discovered.Subnets.FindByID("subnet-b").IsPublic).To(BeFalse())
+	g.Expect(discovered.Subnets.FindByID("subnet-b").AvailabilityZone).To(Equal("us-east-1b"))
+
+	g.Expect(discovered.SecurityGroups).To(HaveLen(2))
+	g.Expect(discovered.SecurityGroups[infrav1.SecurityGroupControlPlane].ID).To(Equal("sg-1"))
+	g.Expect(discovered.SecurityGroups[infrav1.SecurityGroupNode].ID).To(Equal("sg-2"))
+
+	// A private cluster has no external load balancer.
+	g.Expect(discovered.APIServerELB.Name).To(Equal("test-abcde-int"))
+	g.Expect(discovered.APIServerELB.Scheme).To(Equal(infrav1.ClassicELBSchemeInternal))
+	g.Expect(discovered.APIServerELB.AvailabilityZones).To(Equal([]string{"us-east-1b"}))
+	g.Expect(discovered.APIServerELB.SubnetIDs).To(Equal([]string{"subnet-b"}))
+}
+
+func TestDiscoverAWSInfrastructureMissingResources(t *testing.T) {
+	g := NewWithT(t)
+
+	awsClient := installerAWSClient()
+	awsClient.subnets = nil
+	_, condition, _, err := discoverAWSInfrastructure(awsClient, "test-abcde")
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(condition).To(Equal(infrav1.SubnetsReadyCondition))
+
+	awsClient = installerAWSClient()
+	awsClient.securityGroups = awsClient.securityGroups[1:]
+	_, condition, _, err = discoverAWSInfrastructure(awsClient, "test-abcde")
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(condition).To(Equal(infrav1.ClusterSecurityGroupsReadyCondition))
+
+	awsClient = installerAWSClient()
+	awsClient.loadBalancers = nil
+	_, condition, _, err = discoverAWSInfrastructure(awsClient, "test-abcde")
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(condition).To(Equal(infrav1.LoadBalancerReadyCondition))
+
+	awsClient = installerAWSClient()
+	awsClient.subnets[0].VpcId = aws.String("vpc-2")
+	_, _, _, err = discoverAWSInfrastructure(awsClient, "test-abcde")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestIsOperatorAWSCluster(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(isOperatorAWSCluster(CAPACluster("test", "openshift-cluster-api"))).To(BeTrue())
+
+	foreign := &infrav1.AWSCluster{}
+	g.Expect(isOperatorAWSCluster(foreign)).To(BeFalse())
+
+	foreign.Annotations = map[string]string{managedByAnnotation: "other-tool"}
+	g.Expect(isOperatorAWSCluster(foreign)).To(BeFalse())
+
+	owned := &infrav1.AWSCluster{}
+	owned.OwnerReferences = []metav1.OwnerReference{
+		{APIVersion: operatorv1.GroupVersion.String(), Kind: "CAPIDeployment", Name: "cluster"},
+	}
+	g.Expect(isOperatorAWSCluster(owned)).To(BeTrue())
+}
+
+func TestMachineToAWSClusters(t *testing.T) {
+	g := NewWithT(t)
+
+	r := &AWSClusterReconciler{
+		Client: newFakeClient(
+			&infrav1.AWSCluster{ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "openshift-cluster-api",
+				Name:        "cluster",
+				Annotations: map[string]string{managedByAnnotation: managedByValue},
+			}},
+			// Not created by the operator.
+			&infrav1.AWSCluster{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "cluster"}},
+		),
+		Log: ctrl.Log,
+	}
+
+	machine := &metav1.ObjectMeta{Namespace: machineAPINamespace, Name: "test-abcde-master-0"}
+	g.Expect(r.machineToAWSClusters(handler.MapObject{Meta: machine})).To(ConsistOf(reconcile.Request{
+		NamespacedName: types.NamespacedName{Namespace: "openshift-cluster-api", Name: "cluster"},
+	}))
+
+	g.Expect(machineAPIPredicate().Create(event.CreateEvent{Meta: machine})).To(BeTrue())
+	g.Expect(machineAPIPredicate().Create(event.CreateEvent{Meta: &metav1.ObjectMeta{Namespace: "other", Name: "machine"}})).To(BeFalse())
+}
+
+func TestMachineAPIPredicateUpdate(t *testing.T) {
+	g := NewWithT(t)
+
+	newMachine := func(zone, role string) *unstructured.Unstructured {
+		machine := &unstructured.Unstructured{}
+		machine.SetGroupVersionKind(machineGVK)
+		machine.SetNamespace(machineAPINamespace)
+		machine.SetName("test-abcde-worker-0")
+		machine.SetLabels(map[string]string{machineZoneLabel: zone, machineRoleLabel: role})
+		return machine
+	}
+	update := func(oldMachine, newMachine *unstructured.Unstructured) bool {
+		return machineAPIPredicate().Update(event.UpdateEvent{
+			MetaOld:   oldMachine,
+			ObjectOld: oldMachine,
+			MetaNew:   newMachine,
+			ObjectNew: newMachine,
+		})
+	}
+
+	machine := newMachine("us-east-1a", "worker")
+
+	// Status and other spec changes do not move the Machine between zones.
+	updated := machine.DeepCopy()
+	updated.SetResourceVersion("2")
+	g.Expect(unstructured.SetNestedField(updated.Object, "Running", "status", "phase")).To(Succeed())
+	g.Expect(update(machine, updated)).To(BeFalse())
+
+	g.Expect(update(machine, newMachine("us-east-1b", "worker"))).To(BeTrue())
+	g.Expect(update(machine, newMachine("us-east-1a", "master"))).To(BeTrue())
+
+	other := newMachine("us-east-1b", "worker")
+	other.SetNamespace("other")
+	g.Expect(update(machine, other)).To(BeFalse())
+}
@@ -18,7 +18,12 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
 	"github.com/go-logr/logr"
@@ -29,33 +34,101 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	k8sutilspointer "k8s.io/utils/pointer"
 	infrav1 "sigs.k8s.io/cluster-api-provider-aws/api/v1alpha3"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/conditions"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // CAPIDeploymentReconciler reconciles a CAPIDeployment object
 type CAPIDeploymentReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// APIReader reads secrets, which are not cached cluster wide.
+	APIReader client.Reader
+
+	// credentialsWatches watches the credentials secrets minted into the
+	// CAPIDeployment namespaces.
+	credentialsWatches *namespaceWatches
+}
+
+// namespaceWatches starts a watch per namespace the first time it is asked
+// for that namespace.
+type namespaceWatches struct {
+	sync.Mutex
+	started map[string]bool
+	watch   func(namespace string) error
+}
+
+func (w *namespaceWatches) ensure(namespace string) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.started[namespace] {
+		return nil
+	}
+	if err := w.watch(namespace); err != nil {
+		return err
+	}
+	w.started[namespace] = true
+	return nil
 }
 
 const (
 	globalInfrastuctureName = "cluster"
+
+	// managedByAnnotation marks infrastructure clusters whose infrastructure
+	// is managed outside of Cluster API, by the OpenShift installer.
+	managedByAnnotation = "cluster.x-k8s.io/managed-by"
+
+	// managedByValue is the managed-by annotation value identifying
+	// infrastructure clusters created by this operator.
+	managedByValue = "openshift-cluster-api-operator"
+
+	defaultProviderReplicas     = 1
+	defaultProviderLogVerbosity = 4
+
+	notReadyRequeueInterval = 30 * time.Second
+	deletionRequeueInterval = 10 * time.Second
+
+	// managerDeletionTimeout bounds how long an object being deleted waits
+	// for a manager that is not available to remove its finalizers.
+	managerDeletionTimeout = 5 * time.Minute
 )
 
 // +kubebuilder:rbac:groups=capi.openshift.io,resources=capideployments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=capi.openshift.io,resources=capideployments/status,verbs=get;update;patch
-
-func (r *CAPIDeploymentReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+// +kubebuilder:rbac:groups=config.openshift.io,resources=infrastructures,verbs=get;list;watch
+// +kubebuilder:rbac:groups=config.openshift.io,resources=authentications,verbs=get;list;watch
+// +kubebuilder:rbac:groups=operator.openshift.io,resources=cloudcredentials,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterrolebindings;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;roles,verbs=get;list;watch;create;update;patch;delete;escalate;bind
+
+func (r *CAPIDeploymentReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error) {
 	ctx := context.Background()
-	_ = r.Log.WithValues("capideployment", req.NamespacedName)
+	log := r.Log.WithValues("capideployment", req.NamespacedName)
 
 	capiDeployment := &operatorv1.CAPIDeployment{}
 
@@ -63,129 +136,610 @@ func (r *CAPIDeploymentReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 		if apierrors.IsNotFound(err) {
 			// Object not found, return. Created objects are automatically garbage collected.
 			// For additional cleanup logic use finalizers.
+			setCAPIDeploymentAvailable(req.Namespace, req.Name, true)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return ctrl.Result{}, err
 	}
 
-	infra := &configv1.Infrastructure{}
-	if err := r.Client.Get(ctx, types.NamespacedName{Name: globalInfrastuctureName}, infra); err != nil {
+	if capiDeployment.DeletionTimestamp.IsZero() && !controllerutil.ContainsFinalizer(capiDeployment, operatorv1.CAPIDeploymentFinalizer) {
+		finalizerPatch := client.MergeFrom(capiDeployment.DeepCopy())
+		controllerutil.AddFinalizer(capiDeployment, operatorv1.CAPIDeploymentFinalizer)
+		if err := r.Client.Patch(ctx, capiDeployment, finalizerPatch); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to add finalizer: %w", err)
+		}
+	}
+
+	// Always write back what was observed during this reconcile, including
+	// the error that caused it to bail out early.
+	statusPatch := client.MergeFrom(capiDeployment.DeepCopy())
+	defer func() {
+		if reterr != nil {
+			r.Recorder.Event(capiDeployment, corev1.EventTypeWarning, reconcileFailedEventReason, reterr.Error())
+		}
+		setSummaryConditions(capiDeployment, reterr)
+		capiDeployment.Status.ObservedGeneration = capiDeployment.Generation
+		released := !capiDeployment.DeletionTimestamp.IsZero() && !controllerutil.ContainsFinalizer(capiDeployment, operatorv1.CAPIDeploymentFinalizer)
+		setCAPIDeploymentAvailable(capiDeployment.Namespace, capiDeployment.Name, released || conditions.IsTrue(capiDeployment, operatorv1.AvailableCondition))
+
+		// Once the finalizer is released the object may already be gone.
+		if err := r.Client.Status().Patch(ctx, capiDeployment, statusPatch); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "Failed to patch CAPIDeployment status")
+			if reterr == nil {
+				reterr = err
+			}
+		}
+	}()
+
+	admitted, err := r.admittedCAPIDeployment(ctx, capiDeployment)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if admitted.UID != capiDeployment.UID {
+		conditions.MarkFalse(capiDeployment, operatorv1.AdmittedCondition, operatorv1.DuplicateCAPIDeploymentReason, clusterv1.ConditionSeverityError, "CAPIDeployment %q already deploys Cluster API in namespace %q", admitted.Name, admitted.Namespace)
+		if !capiDeployment.DeletionTimestamp.IsZero() {
+			// Everything in the namespace belongs to the admitted one.
+			return ctrl.Result{}, r.removeFinalizer(ctx, capiDeployment)
+		}
+		return ctrl.Result{}, nil
+	}
+	conditions.MarkTrue(capiDeployment, operatorv1.AdmittedCondition)
+
+	if !capiDeployment.DeletionTimestamp.IsZero() {
+		return r.reconcileDelete(ctx, capiDeployment)
+	}
+
+	return r.reconcile(ctx, capiDeployment)
+}
+
+func (r *CAPIDeploymentReconciler) reconcile(ctx context.Context, capiDeployment *operatorv1.CAPIDeployment) (ctrl.Result, error) {
+	if r.credentialsWatches != nil {
+		if err := r.credentialsWatches.ensure(capiDeployment.Namespace); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	infra, err := getClusterInfrastructure(ctx, r.Client)
+	if err != nil {
+		conditions.MarkFalse(capiDeployment, operatorv1.InfrastructureReadyCondition, operatorv1.InfrastructureNotFoundReason, clusterv1.ConditionSeverityError, "%v", err)
 		return ctrl.Result{}, fmt.Errorf("failed to get infrastructure object: %w", err)
 	}
 
+	provider, err := getInfrastructureProvider(infra)
+	if err != nil {
+		conditions.MarkFalse(capiDeployment, operatorv1.InfrastructureReadyCondition, operatorv1.PlatformNotSupportedReason, clusterv1.ConditionSeverityError, "%v", err)
+		return ctrl.Result{}, err
+	}
+
+	if name := capiDeployment.Spec.InfrastructureProvider.Name; name != "" && name != operatorv1.InfrastructureProviderName(infra.Infrastructure) {
+		err := fmt.Errorf("infrastructure provider %q does not match the cluster platform", name)
+		conditions.MarkFalse(capiDeployment, operatorv1.InfrastructureReadyCondition, operatorv1.PlatformNotSupportedReason, clusterv1.ConditionSeverityError, "%v", err)
+		return ctrl.Result{}, err
+	}
+
+	if err := provider.ValidatePlatformStatus(infra); err != nil {
+		reason := operatorv1.PlatformStatusMissingReason
+		if errors.Is(err, errPlatformConfigInvalid) {
+			reason = operatorv1.PlatformConfigInvalidReason
+		}
+		conditions.MarkFalse(capiDeployment, operatorv1.InfrastructureReadyCondition, reason, clusterv1.ConditionSeverityError, "%v", err)
+		return ctrl.Result{}, err
+	}
+
+	controlPlaneEndpoint, err := getControlPlaneEndpoint(infra)
+	if err != nil {
+		conditions.MarkFalse(capiDeployment, operatorv1.InfrastructureReadyCondition, operatorv1.PlatformStatusMissingReason, clusterv1.ConditionSeverityError, "%v", err)
+		return ctrl.Result{}, err
+	}
+
+	infraCluster := provider.InfrastructureCluster(capiDeployment.Name, capiDeployment.Namespace)
+	infraClusterGVK, err := apiutil.GVKForObject(infraCluster, r.Scheme)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to get infrastructure cluster kind: %w", err)
+	}
+
 	// Reconcile the CAPI Cluster resource
 	capiCluster := CAPICluster(capiDeployment.Name, capiDeployment.Namespace)
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, capiCluster, func() error {
-		return r.reconcileCAPICluster(capiCluster, capiDeployment.Name, capiDeployment.Namespace)
+	start := time.Now()
+	_, err = r.createOrUpdate(ctx, capiDeployment, capiCluster, func() error {
+		if err := controllerutil.SetControllerReference(capiDeployment, capiCluster, r.Scheme); err != nil {
+			return err
+		}
+		return r.reconcileCAPICluster(capiCluster, infraClusterGVK, capiDeployment.Name, capiDeployment.Namespace, controlPlaneEndpoint)
 	})
+	observeReconcile(capiDeployment.Namespace, clusterComponent, start, err)
 	if err != nil {
+		conditions.MarkFalse(capiDeployment, operatorv1.InfrastructureReadyCondition, operatorv1.ClusterReconcileFailedReason, clusterv1.ConditionSeverityError, "%v", err)
 		return ctrl.Result{}, fmt.Errorf("failed to reconcile capi cluster: %w", err)
 	}
+	setComponentStatus(capiDeployment, "Cluster", capiCluster.Name, true, "")
 
-	// Create CAPA Cluster
-	region := getAWSRegion(infra)
-	if region == "" {
-		return ctrl.Result{}, fmt.Errorf("region can't be nil, something went wrong")
+	// Reconcile the infrastructure cluster resource
+	start = time.Now()
+	_, err = r.createOrUpdate(ctx, capiDeployment, infraCluster, func() error {
+		if err := controllerutil.SetControllerReference(capiDeployment, infraCluster, r.Scheme); err != nil {
+			return err
+		}
+		return provider.ReconcileInfrastructureCluster(infraCluster, infra)
+	})
+	if err != nil {
+		observeReconcile(capiDeployment.Namespace, infrastructureClusterComponent, start, err)
+		conditions.MarkFalse(capiDeployment, operatorv1.InfrastructureReadyCondition, operatorv1.InfrastructureClusterReconcileFailedReason, clusterv1.ConditionSeverityError, "%v", err)
+		return ctrl.Result{}, fmt.Errorf("failed to reconcile %s: %w", infraClusterGVK.Kind, err)
 	}
 
-	capaCluster := CAPACluster(capiDeployment.Name, capiDeployment.Namespace)
-	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, capaCluster, func() error {
-		return r.reconcileCAPACluster(capaCluster, region)
-	})
+	err = provider.ReconcileInfrastructureClusterStatus(ctx, r.Client, infraCluster)
+	observeReconcile(capiDeployment.Namespace, infrastructureClusterComponent, start, err)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("failed to reconcile capa cluster: %w", err)
+		conditions.MarkFalse(capiDeployment, operatorv1.InfrastructureReadyCondition, operatorv1.InfrastructureClusterReconcileFailedReason, clusterv1.ConditionSeverityError, "%v", err)
+		return ctrl.Result{}, fmt.Errorf("failed to reconcile %s status: %w", infraClusterGVK.Kind, err)
 	}
 
-	err = r.reconcileCAPIComponents(ctx, capiDeployment.Namespace)
+	infraReady, err := provider.InfrastructureClusterReady(infraCluster)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("failed to reconcile capi components: %w", err)
+		return ctrl.Result{}, fmt.Errorf("failed to check %s readiness: %w", infraClusterGVK.Kind, err)
+	}
+	if infraReady {
+		setComponentStatus(capiDeployment, infraClusterGVK.Kind, infraCluster.GetName(), true, "")
+		conditions.MarkTrue(capiDeployment, operatorv1.InfrastructureReadyCondition)
+	} else {
+		setComponentStatus(capiDeployment, infraClusterGVK.Kind, infraCluster.GetName(), false, fmt.Sprintf("%s is not ready", infraClusterGVK.Kind))
+		conditions.MarkFalse(capiDeployment, operatorv1.InfrastructureReadyCondition, operatorv1.WaitingForInfrastructureReason, clusterv1.ConditionSeverityInfo, "Waiting for %s %q to become ready", infraClusterGVK.Kind, infraCluster.GetName())
 	}
 
-	err = r.reconcileCAPAComponents(ctx, capiDeployment.Namespace)
+	err = r.reconcileCAPIComponents(ctx, capiDeployment)
 	if err != nil {
+		conditions.MarkFalse(capiDeployment, operatorv1.ProvidersReadyCondition, operatorv1.ProviderReconcileFailedReason, clusterv1.ConditionSeverityError, "%v", err)
 		return ctrl.Result{}, fmt.Errorf("failed to reconcile capi components: %w", err)
 	}
 
+	// The provider manager mounts its credentials, so it is not deployed
+	// until they exist.
+	start = time.Now()
+	err = provider.ReconcileCredentials(ctx, r.eventingClient(uncachedSecretsClient(r.Client, r.APIReader), capiDeployment), capiDeployment, infra)
+	observeReconcile(capiDeployment.Namespace, credentialsComponent, start, err)
+	if errors.Is(err, errCredentialsNotReady) {
+		conditions.MarkFalse(capiDeployment, operatorv1.CredentialsReadyCondition, operatorv1.WaitingForCredentialsReason, clusterv1.ConditionSeverityWarning, "%v", err)
+		return ctrl.Result{RequeueAfter: notReadyRequeueInterval}, nil
+	}
+	if err != nil {
+		conditions.MarkFalse(capiDeployment, operatorv1.CredentialsReadyCondition, operatorv1.CredentialsReconcileFailedReason, clusterv1.ConditionSeverityError, "%v", err)
+		return ctrl.Result{}, fmt.Errorf("failed to reconcile provider credentials: %w", err)
+	}
+	conditions.MarkTrue(capiDeployment, operatorv1.CredentialsReadyCondition)
+
+	err = r.reconcileInfrastructureProviderComponents(ctx, capiDeployment, provider, infra)
+	if err != nil {
+		conditions.MarkFalse(capiDeployment, operatorv1.ProvidersReadyCondition, operatorv1.ProviderReconcileFailedReason, clusterv1.ConditionSeverityError, "%v", err)
+		return ctrl.Result{}, fmt.Errorf("failed to reconcile infrastructure provider components: %w", err)
+	}
+
+	err = r.deleteOrphanedClusterRoleBindings(ctx, capiDeployment, []managerRBAC{capiManagerRBAC, provider.ManagerRBAC()})
+	if err != nil {
+		conditions.MarkFalse(capiDeployment, operatorv1.ProvidersReadyCondition, operatorv1.ProviderReconcileFailedReason, clusterv1.ConditionSeverityError, "%v", err)
+		return ctrl.Result{}, err
+	}
+
+	providersReady, err := r.reconcileProvidersStatus(ctx, capiDeployment, []*appsv1.Deployment{
+		ClusterAPIManagerDeployment(capiDeployment.Namespace),
+		provider.ManagerDeployment(capiDeployment.Namespace),
+	})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to check provider status: %w", err)
+	}
+
+	// The provider Deployments are watched, but the infrastructure clusters
+	// of providers without Go types are not, so poll until they are ready.
+	if !providersReady || !infraReady {
+		return ctrl.Result{RequeueAfter: notReadyRequeueInterval}, nil
+	}
+
 	return ctrl.Result{}, nil
 }
 
+// reconcileDelete tears down everything created for a CAPIDeployment. The
+// CAPI Cluster goes first, while the provider managers are still running and
+// able to clean up after it; the managers and their bindings are removed only
+// once the Cluster and its infrastructure cluster are gone.
+//
+// Teardown does not depend on the platform: when the provider cannot be
+// resolved, the objects of every known provider are removed by name and only
+// provider specific cleanup, such as the infrastructure cluster and the
+// CredentialsRequest, is skipped. Owned credentials secrets are left to the
+// garbage collector.
+func (r *CAPIDeploymentReconciler) reconcileDelete(ctx context.Context, capiDeployment *operatorv1.CAPIDeployment) (ctrl.Result, error) {
+	log := r.Log.WithValues("capideployment", types.NamespacedName{Namespace: capiDeployment.Namespace, Name: capiDeployment.Name})
+
+	if !controllerutil.ContainsFinalizer(capiDeployment, operatorv1.CAPIDeploymentFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	conditions.MarkFalse(capiDeployment, operatorv1.InfrastructureReadyCondition, operatorv1.DeletingReason, clusterv1.ConditionSeverityInfo, "")
+
+	var provider infrastructureProvider
+	infra, err := getClusterInfrastructure(ctx, r.Client)
+	if err == nil {
+		provider, err = getInfrastructureProvider(infra)
+	}
+	if err != nil {
+		log.Info("Skipping provider specific cleanup", "reason", err.Error())
+	}
+
+	capiCluster := CAPICluster(capiDeployment.Name, capiDeployment.Namespace)
+	gone, err := r.deleteAndCheckGone(ctx, capiCluster)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to delete capi cluster: %w", err)
+	}
+	if !gone {
+		return r.waitForDeletion(ctx, capiDeployment, capiCluster, "Cluster", ClusterAPIManagerDeployment(capiDeployment.Namespace))
+	}
+
+	providers := []infrastructureProvider{provider}
+	if provider != nil {
+		// CAPI deletes the infrastructure cluster along with the Cluster, but
+		// it is removed explicitly too in case the Cluster never referenced it.
+		infraCluster := provider.InfrastructureCluster(capiDeployment.Name, capiDeployment.Namespace)
+		gone, err = r.deleteAndCheckGone(ctx, infraCluster)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to delete infrastructure cluster: %w", err)
+		}
+		if !gone {
+			return r.waitForDeletion(ctx, capiDeployment, infraCluster, "infrastructure cluster", provider.ManagerDeployment(capiDeployment.Namespace))
+		}
+	} else {
+		providers = allInfrastructureProviders()
+	}
+
+	deployments := []*appsv1.Deployment{ClusterAPIManagerDeployment(capiDeployment.Namespace)}
+	rbacs := []managerRBAC{capiManagerRBAC}
+	for _, p := range providers {
+		deployments = append(deployments, p.ManagerDeployment(capiDeployment.Namespace))
+		rbacs = append(rbacs, p.ManagerRBAC())
+	}
+
+	for _, deployment := range deployments {
+		if _, err := r.deleteAndCheckGone(ctx, deployment); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to delete deployment %s: %w", deployment.Name, err)
+		}
+		deleteProviderMetrics(deployment.Namespace, deployment.Name)
+	}
+
+	if provider != nil {
+		if err := provider.DeleteCredentials(ctx, r.Client, capiDeployment.Namespace); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to delete provider credentials: %w", err)
+		}
+	}
+
+	for _, rbac := range rbacs {
+		if err := r.deleteManagerRBAC(ctx, rbac, capiDeployment.Namespace); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Bindings are also found by their owner labels, whatever provider they
+	// were created for.
+	if err := r.deleteOrphanedClusterRoleBindings(ctx, capiDeployment, nil); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Removing finalizer")
+	return ctrl.Result{}, r.removeFinalizer(ctx, capiDeployment)
+}
+
+// waitForDeletion waits for the manager Deployment owning the finalizers of
+// obj to remove them. A manager that is not available, for instance because
+// it never started, cannot do so: once managerDeletionTimeout has passed
+// since the deletion the finalizers are removed instead. The infrastructure
+// is managed outside of Cluster API, so there is nothing left for the manager
+// to clean up.
+func (r *CAPIDeploymentReconciler) waitForDeletion(ctx context.Context, capiDeployment *operatorv1.CAPIDeployment, obj controllerutil.Object, kind string, manager *appsv1.Deployment) (ctrl.Result, error) {
+	log := r.Log.WithValues("capideployment", types.NamespacedName{Namespace: capiDeployment.Namespace, Name: capiDeployment.Name})
+
+	available, err := r.managerAvailable(ctx, manager)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if available {
+		log.Info("Waiting for "+kind+" to be deleted", "name", obj.GetName())
+		markDeleting(capiDeployment, "Waiting for %s %q to be deleted", kind, obj.GetName())
+		return ctrl.Result{RequeueAfter: deletionRequeueInterval}, nil
+	}
+
+	deletionTimestamp := obj.GetDeletionTimestamp()
+	if deletionTimestamp == nil || time.Since(deletionTimestamp.Time) < managerDeletionTimeout {
+		log.Info("Waiting for "+kind+" to be deleted, its manager is not available", "name", obj.GetName(), "manager", manager.Name)
+		markDeleting(capiDeployment, "Waiting for %s %q to be deleted, %s is not available", kind, obj.GetName(), manager.Name)
+		return ctrl.Result{RequeueAfter: deletionRequeueInterval}, nil
+	}
+
+	log.Info("Removing finalizers of "+kind+", its manager is not available", "name", obj.GetName(), "manager", manager.Name)
+	markDeleting(capiDeployment, "Removing finalizers of %s %q, %s is not available", kind, obj.GetName(), manager.Name)
+	finalizersPatch := client.MergeFrom(obj.DeepCopyObject())
+	obj.SetFinalizers(nil)
+	if err := r.Client.Patch(ctx, obj, finalizersPatch); client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove finalizers of %s %s: %w", kind, obj.GetName(), err)
+	}
+	return ctrl.Result{RequeueAfter: deletionRequeueInterval}, nil
+}
+
+// managerAvailable reports whether a manager Deployment has a replica
+// available.
+func (r *CAPIDeploymentReconciler) managerAvailable(ctx context.Context, deployment *appsv1.Deployment) (bool, error) {
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}, deployment); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get deployment %s: %w", deployment.Name, err)
+	}
+	return deployment.Status.AvailableReplicas > 0, nil
+}
+
+func (r *CAPIDeploymentReconciler) removeFinalizer(ctx context.Context, capiDeployment *operatorv1.CAPIDeployment) error {
+	finalizerPatch := client.MergeFrom(capiDeployment.DeepCopy())
+	controllerutil.RemoveFinalizer(capiDeployment, operatorv1.CAPIDeploymentFinalizer)
+	if err := r.Client.Patch(ctx, capiDeployment, finalizerPatch); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to remove finalizer: %w", err)
+	}
+	return nil
+}
+
+// deleteAndCheckGone issues a delete for obj unless it is already being
+// deleted, and reports whether the object no longer exists.
+func (r *CAPIDeploymentReconciler) deleteAndCheckGone(ctx context.Context, obj controllerutil.Object) (bool, error) {
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	if err := r.Client.Get(ctx, key, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	if obj.GetDeletionTimestamp().IsZero() {
+		if err := r.Client.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationForeground)); err != nil {
+			if apierrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+func markDeleting(capiDeployment *operatorv1.CAPIDeployment, messageFormat string, messageArgs ...interface{}) {
+	conditions.Set(capiDeployment, &clusterv1.Condition{
+		Type:    operatorv1.ProgressingCondition,
+		Status:  corev1.ConditionTrue,
+		Reason:  operatorv1.DeletingReason,
+		Message: fmt.Sprintf(messageFormat, messageArgs...),
+	})
+}
+
 func (r *CAPIDeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	c, err := ctrl.NewControllerManagedBy(mgr).
 		For(&operatorv1.CAPIDeployment{}).
-		Complete(r)
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&clusterv1.Cluster{}).
+		Owns(&infrav1.AWSCluster{}).
+		Watches(
+			&source.Kind{Type: &operatorv1.CAPIDeployment{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.capiDeploymentToPeers)},
+		).
+		Watches(
+			&source.Kind{Type: &configv1.Infrastructure{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.infrastructureToCAPIDeployments)},
+		).
+		Watches(
+			&source.Kind{Type: &rbacv1.ClusterRoleBinding{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.clusterRoleBindingToCAPIDeployments)},
+		).
+		Build(r)
+	if err != nil {
+		return err
+	}
+
+	// Caching every secret in the cluster to notice credential rotation is
+	// too costly, so secrets are only cached in kube-system, for the cloud
+	// credentials every CAPIDeployment renders its provider credentials from,
+	// and in the namespaces of CAPIDeployments, for the credentials minted
+	// into them. The latter are watched once a CAPIDeployment is reconciled.
+	r.credentialsWatches = &namespaceWatches{
+		started: map[string]bool{},
+		watch: func(namespace string) error {
+			return watchSecrets(mgr, c, namespace,
+				&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.namespaceCredentialsToCAPIDeployments)},
+				namespaceCredentialsPredicate(),
+			)
+		},
+	}
+
+	return watchSecrets(mgr, c, cloudCredentialsNamespace,
+		&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.cloudCredentialsToCAPIDeployments)},
+		cloudCredentialsPredicate(),
+	)
 }
 
-func getAWSRegion(infra *configv1.Infrastructure) string {
-	if infra.Status.PlatformStatus == nil || infra.Status.PlatformStatus.AWS == nil {
-		return ""
+// watchSecrets watches the secrets of a namespace through a cache of their
+// own. It may be called once the manager is running.
+func watchSecrets(mgr ctrl.Manager, c controller.Controller, namespace string, h handler.EventHandler, p predicate.Predicate) error {
+	secretsCache, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme:    mgr.GetScheme(),
+		Mapper:    mgr.GetRESTMapper(),
+		Namespace: namespace,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create secrets cache for namespace %s: %w", namespace, err)
+	}
+	if err := c.Watch(source.NewKindWithCache(&corev1.Secret{}, secretsCache), h, p); err != nil {
+		return fmt.Errorf("failed to watch secrets in namespace %s: %w", namespace, err)
 	}
+	return mgr.Add(secretsCache)
+}
 
-	return infra.Status.PlatformStatus.AWS.Region
+// infrastructureToCAPIDeployments requeues every CAPIDeployment when the
+// Infrastructure object changes, e.g. when the API server URL moves.
+func (r *CAPIDeploymentReconciler) infrastructureToCAPIDeployments(o handler.MapObject) []reconcile.Request {
+	return r.capiDeploymentRequests()
 }
 
-func CAPICluster(name, namespace string) *clusterv1.Cluster {
-	return &clusterv1.Cluster{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-		},
+// capiDeploymentToPeers requeues the other CAPIDeployments of a namespace, so
+// that a duplicate is admitted once the admitted CAPIDeployment is gone.
+func (r *CAPIDeploymentReconciler) capiDeploymentToPeers(o handler.MapObject) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, request := range r.capiDeploymentRequests(client.InNamespace(o.Meta.GetNamespace())) {
+		if request.Name != o.Meta.GetName() {
+			requests = append(requests, request)
+		}
 	}
+	return requests
 }
 
-func (r *CAPIDeploymentReconciler) reconcileCAPICluster(cluster *clusterv1.Cluster, infraName, infraNamespace string) error {
-	cluster.Spec = clusterv1.ClusterSpec{
-		InfrastructureRef: &corev1.ObjectReference{
-			APIVersion: "infrastructure.cluster.x-k8s.io/v1alpha3",
-			Kind:       "AWSCluster",
-			Namespace:  infraNamespace,
-			Name:       infraName,
-		},
+// cloudCredentialsToCAPIDeployments requeues every CAPIDeployment when the
+// cloud credentials of the cluster change, as they all render their provider
+// credentials from them.
+func (r *CAPIDeploymentReconciler) cloudCredentialsToCAPIDeployments(o handler.MapObject) []reconcile.Request {
+	return r.capiDeploymentRequests()
+}
+
+// namespaceCredentialsToCAPIDeployments requeues the CAPIDeployments of the
+// namespace a credentials secret was minted into.
+func (r *CAPIDeploymentReconciler) namespaceCredentialsToCAPIDeployments(o handler.MapObject) []reconcile.Request {
+	return r.capiDeploymentRequests(client.InNamespace(o.Meta.GetNamespace()))
+}
+
+// cloudCredentialsSecrets are the secrets the installer stores the cloud
+// credentials of the cluster in, one per platform.
+var cloudCredentialsSecrets = []types.NamespacedName{
+	awsCredentialsSecret,
+	azureCredentialsSecret,
+	gcpCredentialsSecret,
+	openstackCredentialsSecret,
+	defaultVSphereCredentialsSecret,
+}
+
+func isCloudCredentialsSecret(meta metav1.Object) bool {
+	for _, secret := range cloudCredentialsSecrets {
+		if meta.GetNamespace() == secret.Namespace && meta.GetName() == secret.Name {
+			return true
+		}
 	}
+	return false
+}
 
-	return nil
+// namespaceCredentialsSecretNames are the secrets the cloud credential
+// operator mints into the namespace of a CAPIDeployment.
+var namespaceCredentialsSecretNames = []string{
+	capaCloudCredentialsSecretName,
 }
 
-func CAPACluster(name, namespace string) *infrav1.AWSCluster {
-	return &infrav1.AWSCluster{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace:   namespace,
-			Name:        name,
-			Annotations: map[string]string{"cluster.x-k8s.io/managed-by": ""},
+func isNamespaceCredentialsSecret(meta metav1.Object) bool {
+	for _, name := range namespaceCredentialsSecretNames {
+		if meta.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// cloudCredentialsPredicate filters out the secrets other than the cloud
+// credentials of the cluster.
+func cloudCredentialsPredicate() predicate.Funcs {
+	return metaPredicate(isCloudCredentialsSecret)
+}
+
+// namespaceCredentialsPredicate filters out the secrets other than the
+// credentials minted into a CAPIDeployment namespace.
+func namespaceCredentialsPredicate() predicate.Funcs {
+	return metaPredicate(isNamespaceCredentialsSecret)
+}
+
+// metaPredicate filters events by the metadata of their object.
+func metaPredicate(match func(metav1.Object) bool) predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return match(e.Meta)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return match(e.MetaNew)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return match(e.Meta)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return match(e.Meta)
 		},
 	}
 }
 
-func (r *CAPIDeploymentReconciler) reconcileCAPACluster(awsCluster *infrav1.AWSCluster, region string) error {
-	awsCluster.Annotations = map[string]string{"cluster.x-k8s.io/managed-by": ""}
-	awsCluster.Spec = infrav1.AWSClusterSpec{
-		Region: region,
+// clusterRoleBindingToCAPIDeployments requeues the CAPIDeployment recorded
+// in the owner labels of a ClusterRoleBinding and those whose provider
+// ServiceAccounts it binds. Cluster scoped objects cannot be owned by a
+// CAPIDeployment, so they are mapped through labels and subjects.
+func (r *CAPIDeploymentReconciler) clusterRoleBindingToCAPIDeployments(o handler.MapObject) []reconcile.Request {
+	binding, ok := o.Object.(*rbacv1.ClusterRoleBinding)
+	if !ok {
+		return nil
 	}
 
-	return nil
+	var requests []reconcile.Request
+	if name := binding.Labels[operatorv1.OwnerNameLabel]; name != "" {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: binding.Labels[operatorv1.OwnerNamespaceLabel], Name: name},
+		})
+	}
+
+	namespaces := map[string]struct{}{}
+	for _, subject := range binding.Subjects {
+		if subject.Kind == rbacv1.ServiceAccountKind {
+			namespaces[subject.Namespace] = struct{}{}
+		}
+	}
+	for namespace := range namespaces {
+		requests = append(requests, r.capiDeploymentRequests(client.InNamespace(namespace))...)
+	}
+	return requests
 }
 
-func CAPIManagerClusterRoleBinding() *rbacv1.ClusterRoleBinding {
-	return &rbacv1.ClusterRoleBinding{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "cluster-api",
-		},
+// capiDeploymentRequests returns a request for every CAPIDeployment matching
+// the list options.
+func (r *CAPIDeploymentReconciler) capiDeploymentRequests(opts ...client.ListOption) []reconcile.Request {
+	capiDeployments := &operatorv1.CAPIDeploymentList{}
+	if err := r.Client.List(context.Background(), capiDeployments, opts...); err != nil {
+		r.Log.Error(err, "Failed to list CAPIDeployments")
+		return nil
 	}
+
+	requests := make([]reconcile.Request, 0, len(capiDeployments.Items))
+	for _, capiDeployment := range capiDeployments.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: capiDeployment.Namespace, Name: capiDeployment.Name},
+		})
+	}
+	return requests
 }
 
-func reconcileCAPIManagerClusterRoleBinding(binding *rbacv1.ClusterRoleBinding, namespace string) error {
-	binding.Subjects = []rbacv1.Subject{
-		{
-			Kind:      "ServiceAccount",
-			Name:      "default",
+func CAPICluster(name, namespace string) *clusterv1.Cluster {
+	return &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
 			Namespace: namespace,
 		},
 	}
-	binding.RoleRef = rbacv1.RoleRef{
-		APIGroup: "rbac.authorization.k8s.io",
-		Kind:     "ClusterRole",
-		Name:     "cluster-admin",
+}
+
+func (r *CAPIDeploymentReconciler) reconcileCAPICluster(cluster *clusterv1.Cluster, infraGVK schema.GroupVersionKind, infraName, infraNamespace string, controlPlaneEndpoint clusterv1.APIEndpoint) error {
+	cluster.Spec = clusterv1.ClusterSpec{
+		ControlPlaneEndpoint: controlPlaneEndpoint,
+		InfrastructureRef: &corev1.ObjectReference{
+			APIVersion: infraGVK.GroupVersion().String(),
+			Kind:       infraGVK.Kind,
+			Namespace:  infraNamespace,
+			Name:       infraName,
+		},
 	}
+
 	return nil
 }
 
@@ -198,9 +752,14 @@ func ClusterAPIManagerDeployment(namespace string) *appsv1.Deployment {
 	}
 }
 
-func reconcileCAPIManagerDeployment(deployment *appsv1.Deployment, image string) error {
-	deployment.Spec = appsv1.DeploymentSpec{
-		Replicas: k8sutilspointer.Int32Ptr(1),
+func reconcileCAPIManagerDeployment(deployment *appsv1.Deployment, provider operatorv1.ProviderSpec) error {
+	image, err := providerImage(provider, operatorv1.ClusterAPIProviderName)
+	if err != nil {
+		return err
+	}
+
+	setManagerDeploymentSpec(deployment, appsv1.DeploymentSpec{
+		Replicas: providerReplicas(provider),
 		Selector: &metav1.LabelSelector{
 			MatchLabels: map[string]string{
 				"name": "cluster-api",
@@ -213,7 +772,7 @@ func reconcileCAPIManagerDeployment(deployment *appsv1.Deployment, image string)
 				},
 			},
 			Spec: corev1.PodSpec{
-				ServiceAccountName: "default",
+				ServiceAccountName: capiManagerRBAC.ServiceAccountName,
 				Containers: []corev1.Container{
 					{
 						Name:            "manager",
@@ -224,186 +783,138 @@ func reconcileCAPIManagerDeployment(deployment *appsv1.Deployment, image string)
 								Name: "MY_NAMESPACE",
 								ValueFrom: &corev1.EnvVarSource{
 									FieldRef: &corev1.ObjectFieldSelector{
-										FieldPath: "metadata.namespace",
+										APIVersion: "v1",
+										FieldPath:  "metadata.namespace",
 									},
 								},
 							},
 						},
 						Command: []string{"/manager"},
-						Args:    []string{"--namespace", "$(MY_NAMESPACE)", "--alsologtostderr", "--v=4"},
+						Args:    providerArgs(provider),
 					},
 				},
 			},
 		},
-	}
+	})
 	return nil
 }
 
-func (r *CAPIDeploymentReconciler) reconcileCAPIComponents(ctx context.Context, namespace string) error {
-	clusterRoleBinding := CAPIManagerClusterRoleBinding()
+func (r *CAPIDeploymentReconciler) reconcileCAPIComponents(ctx context.Context, capiDeployment *operatorv1.CAPIDeployment) error {
+	namespace := capiDeployment.Namespace
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, clusterRoleBinding, func() error {
-		return reconcileCAPIManagerClusterRoleBinding(clusterRoleBinding, namespace)
-	})
+	start := time.Now()
+	err := r.reconcileManagerRBAC(ctx, capiDeployment, capiManagerRBAC)
+	observeReconcile(namespace, rbacComponent, start, err)
 	if err != nil {
-		return fmt.Errorf("failed to reconcile capi manager cluster role binding: %w", err)
+		return err
 	}
 
 	deployment := ClusterAPIManagerDeployment(namespace)
 
-	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, deployment, func() error {
-		return reconcileCAPIManagerDeployment(deployment, "us.gcr.io/k8s-artifacts-prod/cluster-api/cluster-api-controller:v0.3.12")
+	start = time.Now()
+	_, err = r.createOrUpdate(ctx, capiDeployment, deployment, func() error {
+		if err := controllerutil.SetControllerReference(capiDeployment, deployment, r.Scheme); err != nil {
+			return err
+		}
+		return reconcileCAPIManagerDeployment(deployment, capiDeployment.Spec.ClusterAPI)
 	})
+	observeReconcile(namespace, deployment.Name, start, err)
 	if err != nil {
 		return fmt.Errorf("failed to reconcile capi manager deployment: %w", err)
 	}
+	setProviderInfo(deployment, providerVersion(capiDeployment.Spec.ClusterAPI, operatorv1.ClusterAPIProviderName))
 
 	return nil
 }
 
-func CAPAManagerClusterRoleBinding() *rbacv1.ClusterRoleBinding {
-	return &rbacv1.ClusterRoleBinding{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "cluster-api-aws",
-		},
-	}
-}
+func (r *CAPIDeploymentReconciler) reconcileInfrastructureProviderComponents(ctx context.Context, capiDeployment *operatorv1.CAPIDeployment, provider infrastructureProvider, infra *clusterInfrastructure) error {
+	namespace := capiDeployment.Namespace
 
-func reconcileCAPAManagerClusterRoleBinding(binding *rbacv1.ClusterRoleBinding, namespace string) error {
-	binding.Subjects = []rbacv1.Subject{
-		{
-			Kind:      "ServiceAccount",
-			Name:      "default",
-			Namespace: namespace,
-		},
+	start := time.Now()
+	err := r.reconcileManagerRBAC(ctx, capiDeployment, provider.ManagerRBAC())
+	observeReconcile(namespace, rbacComponent, start, err)
+	if err != nil {
+		return err
 	}
-	binding.RoleRef = rbacv1.RoleRef{
-		APIGroup: "rbac.authorization.k8s.io",
-		Kind:     "ClusterRole",
-		Name:     "cluster-admin",
+
+	deployment := provider.ManagerDeployment(namespace)
+
+	start = time.Now()
+	_, err = r.createOrUpdate(ctx, capiDeployment, deployment, func() error {
+		if err := controllerutil.SetControllerReference(capiDeployment, deployment, r.Scheme); err != nil {
+			return err
+		}
+		if err := provider.ReconcileManagerDeployment(deployment, capiDeployment.Spec.InfrastructureProvider, infra); err != nil {
+			return err
+		}
+		return setCredentialsHash(ctx, r.APIReader, deployment)
+	})
+	observeReconcile(namespace, deployment.Name, start, err)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile %s deployment: %w", deployment.Name, err)
 	}
+	setProviderInfo(deployment, providerVersion(capiDeployment.Spec.InfrastructureProvider, operatorv1.InfrastructureProviderName(infra.Infrastructure)))
+
 	return nil
 }
 
-func ClusterAPIAWSManagerDeployment(namespace string) *appsv1.Deployment {
-	return &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: namespace,
-			Name:      "capa-controller-manager",
-		},
+// providerImage returns the image configured for a provider, falling back to
+// the image of the release matching its version.
+func providerImage(provider operatorv1.ProviderSpec, name string) (string, error) {
+	if provider.Image != "" {
+		return provider.Image, nil
+	}
+	version := providerVersion(provider, name)
+	image := operatorv1.ProviderImage(name, version)
+	if image == "" {
+		return "", fmt.Errorf("provider %s version %s is not supported", name, version)
 	}
+	return image, nil
 }
 
-func reconcileCAPIAWSProviderDeployment(deployment *appsv1.Deployment, image string) error {
-	deployment.Spec = appsv1.DeploymentSpec{
-		Replicas: k8sutilspointer.Int32Ptr(1),
-		Selector: &metav1.LabelSelector{
-			MatchLabels: map[string]string{
-				"control-plane": "capa-controller-manager",
-			},
-		},
-		Template: corev1.PodTemplateSpec{
-			ObjectMeta: metav1.ObjectMeta{
-				Labels: map[string]string{
-					"control-plane": "capa-controller-manager",
-				},
-			},
-			Spec: corev1.PodSpec{
-				ServiceAccountName:            "default",
-				TerminationGracePeriodSeconds: k8sutilspointer.Int64Ptr(10),
-				Tolerations: []corev1.Toleration{
-					{
-						Key:    "node-role.kubernetes.io/master",
-						Effect: corev1.TaintEffectNoSchedule,
-					},
-				},
-				Volumes: []corev1.Volume{
-					{
-						Name: "credentials",
-						VolumeSource: corev1.VolumeSource{
-							Secret: &corev1.SecretVolumeSource{
-								SecretName: "capa-manager-bootstrap-credentials",
-							},
-						},
-					},
-				},
-				Containers: []corev1.Container{
-					{
-						Name:            "manager",
-						Image:           image,
-						ImagePullPolicy: corev1.PullAlways,
-						VolumeMounts: []corev1.VolumeMount{
-							{
-								Name:      "credentials",
-								MountPath: "/home/.aws",
-							},
-						},
-						Env: []corev1.EnvVar{
-							{
-								Name: "MY_NAMESPACE",
-								ValueFrom: &corev1.EnvVarSource{
-									FieldRef: &corev1.ObjectFieldSelector{
-										FieldPath: "metadata.namespace",
-									},
-								},
-							},
-							{
-								Name:  "AWS_SHARED_CREDENTIALS_FILE",
-								Value: "/home/.aws/credentials",
-							},
-						},
-						Command: []string{"/manager"},
-						Args:    []string{"--namespace", "$(MY_NAMESPACE)", "--alsologtostderr", "--v=4"},
-						Ports: []corev1.ContainerPort{
-							{
-								Name:          "healthz",
-								ContainerPort: 9440,
-								Protocol:      corev1.ProtocolTCP,
-							},
-						},
-						LivenessProbe: &corev1.Probe{
-							Handler: corev1.Handler{
-								HTTPGet: &corev1.HTTPGetAction{
-									Path: "/healthz",
-									Port: intstr.FromString("healthz"),
-								},
-							},
-						},
-						ReadinessProbe: &corev1.Probe{
-							Handler: corev1.Handler{
-								HTTPGet: &corev1.HTTPGetAction{
-									Path: "/readyz",
-									Port: intstr.FromString("healthz"),
-								},
-							},
-						},
-					},
-				},
-			},
-		},
+// providerVersion returns the version configured for a provider, falling
+// back to its default when the CAPIDeployment was not defaulted.
+func providerVersion(provider operatorv1.ProviderSpec, name string) string {
+	if provider.Version != "" {
+		return provider.Version
 	}
-
-	return nil
+	return operatorv1.DefaultProviderVersion(name)
 }
 
-func (r *CAPIDeploymentReconciler) reconcileCAPAComponents(ctx context.Context, namespace string) error {
-	clusterRoleBinding := CAPAManagerClusterRoleBinding()
+func providerReplicas(provider operatorv1.ProviderSpec) *int32 {
+	if provider.Replicas != nil {
+		return k8sutilspointer.Int32Ptr(*provider.Replicas)
+	}
+	return k8sutilspointer.Int32Ptr(defaultProviderReplicas)
+}
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, clusterRoleBinding, func() error {
-		return reconcileCAPAManagerClusterRoleBinding(clusterRoleBinding, namespace)
-	})
-	if err != nil {
-		return fmt.Errorf("failed to reconcile capi manager cluster role binding: %w", err)
+// providerArgs builds the provider manager command line. Extra args and
+// feature gates are sorted so the rendered pod template is stable across
+// reconciles and does not trigger spurious rollouts.
+func providerArgs(provider operatorv1.ProviderSpec) []string {
+	verbosity := int32(defaultProviderLogVerbosity)
+	if provider.LogVerbosity != nil {
+		verbosity = *provider.LogVerbosity
 	}
 
-	deployment := ClusterAPIAWSManagerDeployment(namespace)
+	// Leader election keeps the replicas, and the old and new pods of a
+	// rollout, from reconciling the same objects at once.
+	args := []string{"--namespace", "$(MY_NAMESPACE)", "--enable-leader-election", "--alsologtostderr", fmt.Sprintf("--v=%d", verbosity)}
 
-	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, deployment, func() error {
-		return reconcileCAPIAWSProviderDeployment(deployment, "quay.io/ademicev/cluster-api-aws-controller-amd64:dev")
-	})
-	if err != nil {
-		return fmt.Errorf("failed to reconcile capa manager deployment: %w", err)
+	if len(provider.FeatureGates) > 0 {
+		gates := make([]string, 0, len(provider.FeatureGates))
+		for name, enabled := range provider.FeatureGates {
+			gates = append(gates, fmt.Sprintf("%s=%t", name, enabled))
+		}
+		sort.Strings(gates)
+		args = append(args, "--feature-gates="+strings.Join(gates, ","))
 	}
 
-	return nil
+	extraArgs := make([]string, 0, len(provider.ExtraArgs))
+	for name, value := range provider.ExtraArgs {
+		extraArgs = append(extraArgs, fmt.Sprintf("--%s=%s", name, value))
+	}
+	sort.Strings(extraArgs)
+
+	return append(args, extraArgs...)
 }
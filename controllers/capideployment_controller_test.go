@@ -0,0 +1,371 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	k8sutilspointer "k8s.io/utils/pointer"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileDeleteUnsupportedPlatform(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	deletionTimestamp := metav1.NewTime(time.Now())
+	capiDeployment := &operatorv1.CAPIDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "openshift-cluster-api",
+			Name:              "cluster",
+			Finalizers:        []string{operatorv1.CAPIDeploymentFinalizer},
+			DeletionTimestamp: &deletionTimestamp,
+		},
+	}
+	infra := &configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{Name: globalInfrastuctureName},
+		Status: configv1.InfrastructureStatus{
+			PlatformStatus: &configv1.PlatformStatus{Type: configv1.BareMetalPlatformType},
+		},
+	}
+	binding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "openshift-cluster-api-cluster-api-aws",
+			Labels: map[string]string{
+				operatorv1.OwnerNamespaceLabel: capiDeployment.Namespace,
+				operatorv1.OwnerNameLabel:      capiDeployment.Name,
+			},
+		},
+	}
+
+	r := &CAPIDeploymentReconciler{
+		Client: newFakeClient(capiDeployment, infra, binding, ClusterAPIManagerDeployment(capiDeployment.Namespace)),
+		Log:    ctrl.Log,
+		Scheme: testScheme,
+	}
+
+	result, err := r.reconcileDelete(ctx, capiDeployment)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result).To(Equal(ctrl.Result{}))
+
+	got := &operatorv1.CAPIDeployment{}
+	g.Expect(r.Client.Get(ctx, types.NamespacedName{Namespace: capiDeployment.Namespace, Name: capiDeployment.Name}, got)).To(Succeed())
+	g.Expect(got.Finalizers).To(BeEmpty())
+
+	err = r.Client.Get(ctx, types.NamespacedName{Name: binding.Name}, &rbacv1.ClusterRoleBinding{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	err = r.Client.Get(ctx, types.NamespacedName{Namespace: capiDeployment.Namespace, Name: ClusterAPIManagerDeployment(capiDeployment.Namespace).Name}, ClusterAPIManagerDeployment(capiDeployment.Namespace))
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+}
+
+func TestReconcileDeleteOrder(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	now := metav1.NewTime(time.Now())
+	capiDeployment := &operatorv1.CAPIDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "openshift-cluster-api",
+			Name:              "cluster",
+			Finalizers:        []string{operatorv1.CAPIDeploymentFinalizer},
+			DeletionTimestamp: &now,
+		},
+	}
+	infra := &configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{Name: globalInfrastuctureName},
+		Status: configv1.InfrastructureStatus{
+			InfrastructureName: "test-abcde",
+			PlatformStatus: &configv1.PlatformStatus{
+				Type: configv1.AWSPlatformType,
+				AWS:  &configv1.AWSPlatformStatus{Region: "us-east-1"},
+			},
+		},
+	}
+	// The Cluster is held by the finalizer of the CAPI manager.
+	capiCluster := CAPICluster(capiDeployment.Name, capiDeployment.Namespace)
+	capiCluster.Finalizers = []string{clusterv1.ClusterFinalizer}
+	capiCluster.DeletionTimestamp = &now
+	capiManager := ClusterAPIManagerDeployment(capiDeployment.Namespace)
+	capiManager.Status.AvailableReplicas = 1
+	capaManager := new(awsProvider).ManagerDeployment(capiDeployment.Namespace)
+	awsCluster := CAPACluster(capiDeployment.Name, capiDeployment.Namespace)
+
+	r := &CAPIDeploymentReconciler{
+		Client: newFakeClient(capiDeployment, infra, capiCluster, awsCluster, capiManager, capaManager),
+		Log:    ctrl.Log,
+		Scheme: testScheme,
+	}
+	exists := func(obj controllerutil.Object) bool {
+		err := r.Client.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, obj)
+		g.Expect(client.IgnoreNotFound(err)).NotTo(HaveOccurred())
+		return err == nil
+	}
+
+	// The managers are kept until the Cluster they clean up is gone.
+	result, err := r.reconcileDelete(ctx, capiDeployment)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(deletionRequeueInterval))
+	g.Expect(conditions.GetMessage(capiDeployment, operatorv1.ProgressingCondition)).To(Equal(`Waiting for Cluster "cluster" to be deleted`))
+	g.Expect(exists(capiCluster)).To(BeTrue())
+	g.Expect(exists(awsCluster)).To(BeTrue())
+	g.Expect(exists(ClusterAPIManagerDeployment(capiDeployment.Namespace))).To(BeTrue())
+	g.Expect(exists(new(awsProvider).ManagerDeployment(capiDeployment.Namespace))).To(BeTrue())
+	g.Expect(capiDeployment.Finalizers).To(ContainElement(operatorv1.CAPIDeploymentFinalizer))
+
+	// Once the CAPI manager removed its finalizer, the infrastructure
+	// cluster is deleted, and only then the managers.
+	g.Expect(r.Client.Delete(ctx, CAPICluster(capiDeployment.Name, capiDeployment.Namespace))).To(Succeed())
+	result, err = r.reconcileDelete(ctx, capiDeployment)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(deletionRequeueInterval))
+	g.Expect(exists(CAPACluster(capiDeployment.Name, capiDeployment.Namespace))).To(BeFalse())
+	g.Expect(exists(ClusterAPIManagerDeployment(capiDeployment.Namespace))).To(BeTrue())
+
+	result, err = r.reconcileDelete(ctx, capiDeployment)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result).To(Equal(ctrl.Result{}))
+	g.Expect(exists(ClusterAPIManagerDeployment(capiDeployment.Namespace))).To(BeFalse())
+	g.Expect(exists(new(awsProvider).ManagerDeployment(capiDeployment.Namespace))).To(BeFalse())
+
+	got := &operatorv1.CAPIDeployment{}
+	g.Expect(r.Client.Get(ctx, types.NamespacedName{Namespace: capiDeployment.Namespace, Name: capiDeployment.Name}, got)).To(Succeed())
+	g.Expect(got.Finalizers).To(BeEmpty())
+}
+
+func TestReconcileDeleteManagerUnavailable(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	now := metav1.NewTime(time.Now())
+	capiDeployment := &operatorv1.CAPIDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "openshift-cluster-api",
+			Name:              "cluster",
+			Finalizers:        []string{operatorv1.CAPIDeploymentFinalizer},
+			DeletionTimestamp: &now,
+		},
+	}
+	// The CAPI manager never became available to remove its finalizer.
+	capiCluster := CAPICluster(capiDeployment.Name, capiDeployment.Namespace)
+	capiCluster.Finalizers = []string{clusterv1.ClusterFinalizer}
+	capiCluster.DeletionTimestamp = &now
+
+	r := &CAPIDeploymentReconciler{
+		Client: newFakeClient(capiDeployment, capiCluster, ClusterAPIManagerDeployment(capiDeployment.Namespace)),
+		Log:    ctrl.Log,
+		Scheme: testScheme,
+	}
+	clusterKey := types.NamespacedName{Namespace: capiCluster.Namespace, Name: capiCluster.Name}
+
+	result, err := r.reconcileDelete(ctx, capiDeployment)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(deletionRequeueInterval))
+	g.Expect(conditions.GetMessage(capiDeployment, operatorv1.ProgressingCondition)).To(Equal(`Waiting for Cluster "cluster" to be deleted, capi-controller-manager is not available`))
+	got := &clusterv1.Cluster{}
+	g.Expect(r.Client.Get(ctx, clusterKey, got)).To(Succeed())
+	g.Expect(got.Finalizers).To(ConsistOf(clusterv1.ClusterFinalizer))
+
+	// After the timeout the finalizer is removed in its place.
+	deleted := metav1.NewTime(time.Now().Add(-managerDeletionTimeout))
+	got.DeletionTimestamp = &deleted
+	g.Expect(r.Client.Update(ctx, got)).To(Succeed())
+
+	_, err = r.reconcileDelete(ctx, capiDeployment)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(conditions.GetMessage(capiDeployment, operatorv1.ProgressingCondition)).To(Equal(`Removing finalizers of Cluster "cluster", capi-controller-manager is not available`))
+	got = &clusterv1.Cluster{}
+	g.Expect(r.Client.Get(ctx, clusterKey, got)).To(Succeed())
+	g.Expect(got.Finalizers).To(BeEmpty())
+	g.Expect(capiDeployment.Finalizers).To(ContainElement(operatorv1.CAPIDeploymentFinalizer))
+}
+
+func TestCloudCredentialsPredicate(t *testing.T) {
+	g := NewWithT(t)
+
+	p := cloudCredentialsPredicate()
+	secret := func(namespace, name string) event.CreateEvent {
+		return event.CreateEvent{Meta: &metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+
+	g.Expect(p.Create(secret(cloudCredentialsNamespace, awsCredentialsSecret.Name))).To(BeTrue())
+	g.Expect(p.Create(secret(cloudCredentialsNamespace, "bootstrap-token-abcdef"))).To(BeFalse())
+	g.Expect(p.Create(secret("openshift-cluster-api", awsCredentialsSecret.Name))).To(BeFalse())
+}
+
+func TestReconcileCAPIManagerDeploymentVersion(t *testing.T) {
+	g := NewWithT(t)
+
+	deployment := ClusterAPIManagerDeployment("openshift-cluster-api")
+	provider := operatorv1.ProviderSpec{Name: operatorv1.ClusterAPIProviderName, Version: "v0.3.11"}
+	g.Expect(reconcileCAPIManagerDeployment(deployment, provider)).To(Succeed())
+	g.Expect(deployment.Spec.Template.Spec.Containers[0].Image).To(Equal(operatorv1.ProviderImage(operatorv1.ClusterAPIProviderName, "v0.3.11")))
+
+	// Upgrading the version rolls the manager to the newer image.
+	provider.Version = "v0.3.12"
+	g.Expect(reconcileCAPIManagerDeployment(deployment, provider)).To(Succeed())
+	g.Expect(deployment.Spec.Template.Spec.Containers[0].Image).To(HaveSuffix(":v0.3.12"))
+
+	// An explicit image wins over the version.
+	provider.Image = "quay.io/example/cluster-api:custom"
+	g.Expect(reconcileCAPIManagerDeployment(deployment, provider)).To(Succeed())
+	g.Expect(deployment.Spec.Template.Spec.Containers[0].Image).To(Equal(provider.Image))
+
+	provider.Image = ""
+	provider.Version = "v9.9.9"
+	g.Expect(reconcileCAPIManagerDeployment(deployment, provider)).NotTo(Succeed())
+}
+
+func TestProviderArgs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		provider operatorv1.ProviderSpec
+		expected []string
+	}{
+		{
+			name:     "defaults",
+			provider: operatorv1.ProviderSpec{},
+			expected: []string{"--namespace", "$(MY_NAMESPACE)", "--enable-leader-election", "--alsologtostderr", "--v=4"},
+		},
+		{
+			name: "log verbosity",
+			provider: operatorv1.ProviderSpec{
+				LogVerbosity: k8sutilspointer.Int32Ptr(5),
+			},
+			expected: []string{"--namespace", "$(MY_NAMESPACE)", "--enable-leader-election", "--alsologtostderr", "--v=5"},
+		},
+		{
+			name: "feature gates and extra args are sorted",
+			provider: operatorv1.ProviderSpec{
+				FeatureGates: map[string]bool{"MachinePool": true, "ClusterResourceSet": false},
+				ExtraArgs:    map[string]string{"sync-period": "10m", "metrics-addr": ":8081"},
+			},
+			expected: []string{
+				"--namespace", "$(MY_NAMESPACE)", "--enable-leader-election", "--alsologtostderr", "--v=4",
+				"--feature-gates=ClusterResourceSet=false,MachinePool=true",
+				"--metrics-addr=:8081",
+				"--sync-period=10m",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(providerArgs(tc.provider)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestReconcileCAPIManagerDeploymentProviderSettings(t *testing.T) {
+	g := NewWithT(t)
+
+	deployment := ClusterAPIManagerDeployment("openshift-cluster-api")
+	provider := operatorv1.ProviderSpec{Name: operatorv1.ClusterAPIProviderName}
+	g.Expect(reconcileCAPIManagerDeployment(deployment, provider)).To(Succeed())
+	g.Expect(*deployment.Spec.Replicas).To(BeEquivalentTo(defaultProviderReplicas))
+	g.Expect(deployment.Spec.Template.Spec.Containers[0].Args).To(Equal(providerArgs(provider)))
+
+	provider.Replicas = k8sutilspointer.Int32Ptr(3)
+	provider.LogVerbosity = k8sutilspointer.Int32Ptr(6)
+	provider.ExtraArgs = map[string]string{"sync-period": "10m"}
+	g.Expect(reconcileCAPIManagerDeployment(deployment, provider)).To(Succeed())
+	g.Expect(*deployment.Spec.Replicas).To(BeEquivalentTo(3))
+	g.Expect(deployment.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--enable-leader-election"))
+	g.Expect(deployment.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--v=6"))
+	g.Expect(deployment.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--sync-period=10m"))
+
+	// A later reconcile with the replicas unset goes back to the default.
+	provider.Replicas = nil
+	g.Expect(reconcileCAPIManagerDeployment(deployment, provider)).To(Succeed())
+	g.Expect(*deployment.Spec.Replicas).To(BeEquivalentTo(defaultProviderReplicas))
+}
+
+func TestClusterRoleBindingToCAPIDeployments(t *testing.T) {
+	g := NewWithT(t)
+
+	r := &CAPIDeploymentReconciler{
+		Client: newFakeClient(
+			&operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"}},
+			&operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "cluster"}},
+		),
+		Log: ctrl.Log,
+	}
+	request := func(namespace, name string) reconcile.Request {
+		return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+	}
+	mapBinding := func(binding *rbacv1.ClusterRoleBinding) []reconcile.Request {
+		return r.clusterRoleBindingToCAPIDeployments(handler.MapObject{Meta: binding, Object: binding})
+	}
+
+	// An owned binding maps to its owner, even when it no longer exists.
+	g.Expect(mapBinding(&rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "deleted-cluster-api",
+			Labels: map[string]string{
+				operatorv1.OwnerNamespaceLabel: "deleted",
+				operatorv1.OwnerNameLabel:      "cluster",
+			},
+		},
+	})).To(ConsistOf(request("deleted", "cluster")))
+
+	// A binding the operator did not create maps to the CAPIDeployments of
+	// the namespaces of the ServiceAccounts it binds.
+	g.Expect(mapBinding(&rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-api"},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.ServiceAccountKind, Namespace: "openshift-cluster-api", Name: capiManagerRBAC.ServiceAccountName},
+			{Kind: rbacv1.ServiceAccountKind, Namespace: "openshift-cluster-api", Name: "capa-controller-manager"},
+			{Kind: rbacv1.UserKind, Name: "admin"},
+		},
+	})).To(ConsistOf(request("openshift-cluster-api", "cluster")))
+
+	g.Expect(mapBinding(&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "cluster-admin"}})).To(BeEmpty())
+}
+
+func TestInfrastructureToCAPIDeployments(t *testing.T) {
+	g := NewWithT(t)
+
+	r := &CAPIDeploymentReconciler{
+		Client: newFakeClient(
+			&operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"}},
+			&operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "cluster"}},
+		),
+		Log: ctrl.Log,
+	}
+
+	infra := &configv1.Infrastructure{ObjectMeta: metav1.ObjectMeta{Name: globalInfrastuctureName}}
+	g.Expect(r.infrastructureToCAPIDeployments(handler.MapObject{Meta: infra, Object: infra})).To(ConsistOf(
+		reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "openshift-cluster-api", Name: "cluster"}},
+		reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "other", Name: "cluster"}},
+	))
+}
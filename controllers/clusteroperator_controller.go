@@ -0,0 +1,329 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// clusterOperatorName is the ClusterOperator the operator reports its
+	// health through.
+	clusterOperatorName = "cluster-api"
+
+	// operatorVersionName is the operand version reported for the operator
+	// itself.
+	operatorVersionName = "operator"
+)
+
+// ClusterOperatorReconciler aggregates the status of all CAPIDeployments into
+// the cluster-api ClusterOperator.
+type ClusterOperatorReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// ReleaseVersion is the version of the operator, reported once every
+	// CAPIDeployment is available.
+	ReleaseVersion string
+
+	// OperatorNamespace is the namespace the operator runs in, reported as a
+	// related object.
+	OperatorNamespace string
+}
+
+// +kubebuilder:rbac:groups=config.openshift.io,resources=clusteroperators,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=config.openshift.io,resources=clusteroperators/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=capi.openshift.io,resources=capideployments,verbs=get;list;watch
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterrolebindings,verbs=get;list;watch
+
+func (r *ClusterOperatorReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+
+	if req.Name != clusterOperatorName {
+		return ctrl.Result{}, nil
+	}
+
+	capiDeployments := &operatorv1.CAPIDeploymentList{}
+	if err := r.Client.List(ctx, capiDeployments); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to list CAPIDeployments: %w", err)
+	}
+
+	bindings := &rbacv1.ClusterRoleBindingList{}
+	if err := r.Client.List(ctx, bindings, client.HasLabels{operatorv1.OwnerNamespaceLabel, operatorv1.OwnerNameLabel}); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to list cluster role bindings: %w", err)
+	}
+
+	co := &configv1.ClusterOperator{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: clusterOperatorName}, co); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to get cluster operator: %w", err)
+		}
+
+		co = &configv1.ClusterOperator{ObjectMeta: metav1.ObjectMeta{Name: clusterOperatorName}}
+		if err := r.Client.Create(ctx, co); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to create cluster operator: %w", err)
+		}
+	}
+
+	status := co.Status.DeepCopy()
+	r.reconcileClusterOperatorStatus(status, capiDeployments.Items, bindings.Items)
+	if equalClusterOperatorStatus(&co.Status, status) {
+		return ctrl.Result{}, nil
+	}
+
+	co.Status = *status
+	if err := r.Client.Status().Update(ctx, co); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update cluster operator status: %w", err)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// reconcileClusterOperatorStatus sets the conditions, versions and related
+// objects of the ClusterOperator from the CAPIDeployments and the cluster
+// role bindings created for them.
+func (r *ClusterOperatorReconciler) reconcileClusterOperatorStatus(status *configv1.ClusterOperatorStatus, capiDeployments []operatorv1.CAPIDeployment, bindings []rbacv1.ClusterRoleBinding) {
+	sort.Slice(capiDeployments, func(i, j int) bool {
+		a, b := capiDeployments[i], capiDeployments[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	var unavailable, progressing, degraded, pinned []string
+	for i := range capiDeployments {
+		capiDeployment := &capiDeployments[i]
+		name := capiDeployment.Namespace + "/" + capiDeployment.Name
+
+		if !conditions.IsTrue(capiDeployment, operatorv1.AvailableCondition) {
+			unavailable = append(unavailable, conditionSummary(name, capiDeployment, operatorv1.AvailableCondition))
+		}
+		if conditions.IsTrue(capiDeployment, operatorv1.ProgressingCondition) {
+			progressing = append(progressing, conditionSummary(name, capiDeployment, operatorv1.ProgressingCondition))
+		}
+		if conditions.IsTrue(capiDeployment, operatorv1.DegradedCondition) {
+			degraded = append(degraded, conditionSummary(name, capiDeployment, operatorv1.DegradedCondition))
+		}
+		if capiDeployment.Spec.ClusterAPI.Image != "" || capiDeployment.Spec.InfrastructureProvider.Image != "" {
+			pinned = append(pinned, name)
+		}
+	}
+
+	if len(unavailable) > 0 {
+		setClusterOperatorCondition(status, configv1.OperatorAvailable, configv1.ConditionFalse, "CAPIDeploymentsNotAvailable", strings.Join(unavailable, "; "))
+	} else {
+		setClusterOperatorCondition(status, configv1.OperatorAvailable, configv1.ConditionTrue, operatorv1.AsExpectedReason, fmt.Sprintf("%d CAPIDeployments available", len(capiDeployments)))
+	}
+
+	if len(progressing) > 0 {
+		setClusterOperatorCondition(status, configv1.OperatorProgressing, configv1.ConditionTrue, operatorv1.RolloutInProgressReason, strings.Join(progressing, "; "))
+	} else {
+		setClusterOperatorCondition(status, configv1.OperatorProgressing, configv1.ConditionFalse, operatorv1.AsExpectedReason, "")
+	}
+
+	if len(degraded) > 0 {
+		setClusterOperatorCondition(status, configv1.OperatorDegraded, configv1.ConditionTrue, "CAPIDeploymentsDegraded", strings.Join(degraded, "; "))
+	} else {
+		setClusterOperatorCondition(status, configv1.OperatorDegraded, configv1.ConditionFalse, operatorv1.AsExpectedReason, "")
+	}
+
+	// Providers running an image set by the user are not moved to the
+	// provider versions of a new operator release.
+	if len(pinned) > 0 {
+		setClusterOperatorCondition(status, configv1.OperatorUpgradeable, configv1.ConditionFalse, "ProviderImageOverridden", fmt.Sprintf("Provider images are overridden in %s", strings.Join(pinned, ", ")))
+	} else {
+		setClusterOperatorCondition(status, configv1.OperatorUpgradeable, configv1.ConditionTrue, operatorv1.AsExpectedReason, "")
+	}
+
+	// The version is only reported once it is fully rolled out.
+	if r.ReleaseVersion != "" && len(unavailable) == 0 && len(progressing) == 0 {
+		status.Versions = []configv1.OperandVersion{{Name: operatorVersionName, Version: r.ReleaseVersion}}
+	}
+
+	status.RelatedObjects = clusterOperatorRelatedObjects(r.OperatorNamespace, capiDeployments, bindings)
+}
+
+// conditionSummary describes a condition of a CAPIDeployment in the message
+// of a ClusterOperator condition.
+func conditionSummary(name string, capiDeployment *operatorv1.CAPIDeployment, t clusterv1.ConditionType) string {
+	if message := conditions.GetMessage(capiDeployment, t); message != "" {
+		return fmt.Sprintf("%s: %s", name, message)
+	}
+	if reason := conditions.GetReason(capiDeployment, t); reason != "" {
+		return fmt.Sprintf("%s: %s", name, reason)
+	}
+	return name
+}
+
+// clusterOperatorRelatedObjects lists the objects must-gather collects: the
+// operator's namespace, all CAPIDeployments, their namespaces, the manager
+// Deployments and the cluster role bindings of the managers.
+func clusterOperatorRelatedObjects(operatorNamespace string, capiDeployments []operatorv1.CAPIDeployment, bindings []rbacv1.ClusterRoleBinding) []configv1.ObjectReference {
+	relatedObjects := []configv1.ObjectReference{}
+	namespaces := map[string]struct{}{}
+	if operatorNamespace != "" {
+		namespaces[operatorNamespace] = struct{}{}
+		relatedObjects = append(relatedObjects, configv1.ObjectReference{
+			Resource: "namespaces",
+			Name:     operatorNamespace,
+		})
+	}
+
+	// Also collects CAPIDeployments that do not report status yet.
+	relatedObjects = append(relatedObjects, configv1.ObjectReference{
+		Group:    operatorv1.GroupVersion.Group,
+		Resource: "capideployments",
+	})
+
+	for _, capiDeployment := range capiDeployments {
+		if _, ok := namespaces[capiDeployment.Namespace]; !ok {
+			namespaces[capiDeployment.Namespace] = struct{}{}
+			relatedObjects = append(relatedObjects, configv1.ObjectReference{
+				Resource: "namespaces",
+				Name:     capiDeployment.Namespace,
+			})
+		}
+
+		relatedObjects = append(relatedObjects, configv1.ObjectReference{
+			Group:     operatorv1.GroupVersion.Group,
+			Resource:  "capideployments",
+			Namespace: capiDeployment.Namespace,
+			Name:      capiDeployment.Name,
+		})
+
+		for _, component := range capiDeployment.Status.Components {
+			if component.Kind != "Deployment" {
+				continue
+			}
+			relatedObjects = append(relatedObjects, configv1.ObjectReference{
+				Group:     "apps",
+				Resource:  "deployments",
+				Namespace: capiDeployment.Namespace,
+				Name:      component.Name,
+			})
+		}
+	}
+
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].Name < bindings[j].Name })
+	for _, binding := range bindings {
+		relatedObjects = append(relatedObjects, configv1.ObjectReference{
+			Group:    rbacv1.GroupName,
+			Resource: "clusterrolebindings",
+			Name:     binding.Name,
+		})
+	}
+	return relatedObjects
+}
+
+// setClusterOperatorCondition adds or updates a ClusterOperator condition,
+// keeping its transition time unless the status changes.
+func setClusterOperatorCondition(status *configv1.ClusterOperatorStatus, t configv1.ClusterStatusConditionType, conditionStatus configv1.ConditionStatus, reason, message string) {
+	condition := configv1.ClusterOperatorStatusCondition{
+		Type:               t,
+		Status:             conditionStatus,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+
+	for i := range status.Conditions {
+		existing := &status.Conditions[i]
+		if existing.Type != t {
+			continue
+		}
+		if existing.Status == conditionStatus {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		*existing = condition
+		return
+	}
+	status.Conditions = append(status.Conditions, condition)
+}
+
+// equalClusterOperatorStatus compares statuses ignoring transition times,
+// which only change along with a status.
+func equalClusterOperatorStatus(a, b *configv1.ClusterOperatorStatus) bool {
+	if len(a.Conditions) != len(b.Conditions) {
+		return false
+	}
+	for i := range a.Conditions {
+		x, y := a.Conditions[i], b.Conditions[i]
+		if x.Type != y.Type || x.Status != y.Status || x.Reason != y.Reason || x.Message != y.Message {
+			return false
+		}
+	}
+
+	if len(a.Versions) != len(b.Versions) {
+		return false
+	}
+	for i := range a.Versions {
+		if a.Versions[i] != b.Versions[i] {
+			return false
+		}
+	}
+
+	if len(a.RelatedObjects) != len(b.RelatedObjects) {
+		return false
+	}
+	for i := range a.RelatedObjects {
+		if a.RelatedObjects[i] != b.RelatedObjects[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *ClusterOperatorReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Nothing else creates the ClusterOperator, so it is reconciled once on
+	// start even when there are no CAPIDeployments to trigger it.
+	co := &configv1.ClusterOperator{ObjectMeta: metav1.ObjectMeta{Name: clusterOperatorName}}
+	initial := make(chan event.GenericEvent, 1)
+	initial <- event.GenericEvent{Meta: co, Object: co}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&configv1.ClusterOperator{}).
+		Watches(
+			&source.Kind{Type: &operatorv1.CAPIDeployment{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: clusterOperatorName}}}
+			})},
+		).
+		Watches(&source.Channel{Source: initial}, &handler.EnqueueRequestForObject{}).
+		Complete(r)
+}
@@ -0,0 +1,91 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/conditions"
+)
+
+func TestReconcileClusterOperatorStatus(t *testing.T) {
+	g := NewWithT(t)
+
+	available := operatorv1.CAPIDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"},
+		Status: operatorv1.CAPIDeploymentStatus{
+			Components: []operatorv1.ComponentStatus{
+				{Kind: "Cluster", Name: "cluster", Ready: true},
+				{Kind: "Deployment", Name: "capa-controller-manager", Ready: true},
+			},
+		},
+	}
+	conditions.MarkTrue(&available, operatorv1.AvailableCondition)
+
+	degraded := operatorv1.CAPIDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "cluster"},
+		Spec: operatorv1.CAPIDeploymentSpec{
+			InfrastructureProvider: operatorv1.ProviderSpec{Image: "quay.io/example/capa:dev"},
+		},
+	}
+	conditions.MarkFalse(&degraded, operatorv1.AvailableCondition, operatorv1.WaitingForCredentialsReason, clusterv1.ConditionSeverityWarning, "credentials secret does not exist")
+	conditions.Set(&degraded, conditions.TrueCondition(operatorv1.DegradedCondition))
+
+	bindings := []rbacv1.ClusterRoleBinding{
+		{ObjectMeta: metav1.ObjectMeta{Name: "openshift-cluster-api-capa-manager"}},
+	}
+
+	r := &ClusterOperatorReconciler{ReleaseVersion: "4.7.0", OperatorNamespace: "openshift-cluster-api-operator"}
+
+	status := &configv1.ClusterOperatorStatus{}
+	r.reconcileClusterOperatorStatus(status, []operatorv1.CAPIDeployment{degraded, available}, bindings)
+
+	conditionStatus := map[configv1.ClusterStatusConditionType]configv1.ConditionStatus{}
+	for _, condition := range status.Conditions {
+		conditionStatus[condition.Type] = condition.Status
+	}
+	g.Expect(conditionStatus).To(Equal(map[configv1.ClusterStatusConditionType]configv1.ConditionStatus{
+		configv1.OperatorAvailable:   configv1.ConditionFalse,
+		configv1.OperatorProgressing: configv1.ConditionFalse,
+		configv1.OperatorDegraded:    configv1.ConditionTrue,
+		configv1.OperatorUpgradeable: configv1.ConditionFalse,
+	}))
+	g.Expect(status.Conditions[0].Message).To(Equal("other/cluster: credentials secret does not exist"))
+
+	// The version is held back until every CAPIDeployment is available.
+	g.Expect(status.Versions).To(BeEmpty())
+
+	g.Expect(status.RelatedObjects).To(Equal([]configv1.ObjectReference{
+		{Resource: "namespaces", Name: "openshift-cluster-api-operator"},
+		{Group: "capi.openshift.io", Resource: "capideployments"},
+		{Resource: "namespaces", Name: "openshift-cluster-api"},
+		{Group: "capi.openshift.io", Resource: "capideployments", Namespace: "openshift-cluster-api", Name: "cluster"},
+		{Group: "apps", Resource: "deployments", Namespace: "openshift-cluster-api", Name: "capa-controller-manager"},
+		{Resource: "namespaces", Name: "other"},
+		{Group: "capi.openshift.io", Resource: "capideployments", Namespace: "other", Name: "cluster"},
+		{Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings", Name: "openshift-cluster-api-capa-manager"},
+	}))
+
+	r.reconcileClusterOperatorStatus(status, []operatorv1.CAPIDeployment{available}, nil)
+	g.Expect(status.Versions).To(Equal([]configv1.OperandVersion{{Name: "operator", Version: "4.7.0"}}))
+}
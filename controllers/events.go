@@ -0,0 +1,156 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Reasons of the Events recorded on a CAPIDeployment.
+const (
+	createdEventReason         = "Created"
+	updatedEventReason         = "Updated"
+	reconcileFailedEventReason = "ReconcileFailed"
+)
+
+// changedFieldsDepth is how deep into an object the fields listed in an
+// Updated Event go, e.g. spec.template.
+const changedFieldsDepth = 2
+
+// eventingClient creates and updates objects on behalf of owner, recording
+// an Event on owner for every object it creates or changes.
+type eventingClient struct {
+	client.Client
+	recorder record.EventRecorder
+	scheme   *runtime.Scheme
+	owner    runtime.Object
+}
+
+// createOrUpdate is controllerutil.CreateOrUpdate recording an Event on the
+// CAPIDeployment when obj is created or changed.
+func (r *CAPIDeploymentReconciler) createOrUpdate(ctx context.Context, capiDeployment *operatorv1.CAPIDeployment, obj runtime.Object, f controllerutil.MutateFn) (controllerutil.OperationResult, error) {
+	return r.eventingClient(r.Client, capiDeployment).createOrUpdate(ctx, obj, f)
+}
+
+// eventingClient returns c recording its changes as Events on the
+// CAPIDeployment.
+func (r *CAPIDeploymentReconciler) eventingClient(c client.Client, capiDeployment *operatorv1.CAPIDeployment) *eventingClient {
+	return &eventingClient{Client: c, recorder: r.Recorder, scheme: r.Scheme, owner: capiDeployment}
+}
+
+// createOrUpdate is controllerutil.CreateOrUpdate recording an Event on the
+// owner when obj is created or changed.
+func (c *eventingClient) createOrUpdate(ctx context.Context, obj runtime.Object, f controllerutil.MutateFn) (controllerutil.OperationResult, error) {
+	// The changes are taken from around the mutate function, as the update
+	// itself also changes fields set by the server.
+	var before, after runtime.Object
+	result, err := controllerutil.CreateOrUpdate(ctx, c.Client, obj, func() error {
+		before = obj.DeepCopyObject()
+		if err := f(); err != nil {
+			return err
+		}
+		after = obj.DeepCopyObject()
+		return nil
+	})
+	if err != nil || result == controllerutil.OperationResultNone {
+		return result, err
+	}
+
+	kind := "object"
+	if gvk, err := apiutil.GVKForObject(obj, c.scheme); err == nil {
+		kind = gvk.Kind
+	}
+	var name string
+	if accessor, err := meta.Accessor(obj); err == nil {
+		name = accessor.GetName()
+	}
+
+	switch result {
+	case controllerutil.OperationResultCreated:
+		c.recorder.Eventf(c.owner, corev1.EventTypeNormal, createdEventReason, "Created %s %s", kind, name)
+	case controllerutil.OperationResultUpdated:
+		message := fmt.Sprintf("Updated %s %s", kind, name)
+		if fields := changedFields(before, after); len(fields) > 0 {
+			message += ": changed " + strings.Join(fields, ", ")
+		}
+		c.recorder.Event(c.owner, corev1.EventTypeNormal, updatedEventReason, message)
+	}
+	return result, nil
+}
+
+// changedFields lists the fields, outside of status, that differ between two
+// versions of an object.
+func changedFields(before, after runtime.Object) []string {
+	b, err := objectContent(before)
+	if err != nil {
+		return nil
+	}
+	a, err := objectContent(after)
+	if err != nil {
+		return nil
+	}
+	delete(b, "status")
+	delete(a, "status")
+	return diffFields(b, a, "", changedFieldsDepth)
+}
+
+func objectContent(obj runtime.Object) (map[string]interface{}, error) {
+	if u, ok := obj.(runtime.Unstructured); ok {
+		return runtime.DeepCopyJSON(u.UnstructuredContent()), nil
+	}
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+func diffFields(before, after map[string]interface{}, prefix string, depth int) []string {
+	keys := map[string]struct{}{}
+	for key := range before {
+		keys[key] = struct{}{}
+	}
+	for key := range after {
+		keys[key] = struct{}{}
+	}
+
+	var fields []string
+	for key := range keys {
+		b, a := before[key], after[key]
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		bm, bok := b.(map[string]interface{})
+		am, aok := a.(map[string]interface{})
+		if depth > 1 && bok && aok {
+			fields = append(fields, diffFields(bm, am, prefix+key+".", depth-1)...)
+			continue
+		}
+		fields = append(fields, prefix+key)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,162 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	k8sutilspointer "k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestCreateOrUpdateEvents(t *testing.T) {
+	g := NewWithT(t)
+
+	recorder := record.NewFakeRecorder(10)
+	r := &CAPIDeploymentReconciler{
+		Client:   newFakeClient(),
+		Scheme:   testScheme,
+		Recorder: recorder,
+	}
+	capiDeployment := &operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "cluster"}}
+
+	labels := map[string]string{"app": "capi"}
+	reconcileConfigMap := func() {
+		configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "config"}}
+		_, err := r.createOrUpdate(context.Background(), capiDeployment, configMap, func() error {
+			configMap.Labels = labels
+			configMap.Data = map[string]string{"key": "value"}
+			return nil
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	reconcileConfigMap()
+	g.Expect(recorder.Events).To(Receive(Equal("Normal Created Created ConfigMap config")))
+
+	reconcileConfigMap()
+	g.Expect(recorder.Events).NotTo(Receive())
+
+	labels = map[string]string{"app": "capa"}
+	reconcileConfigMap()
+	g.Expect(recorder.Events).To(Receive(Equal("Normal Updated Updated ConfigMap config: changed metadata.labels")))
+}
+
+// setServerDefaults sets the defaults the API server applies to the fields
+// of a Deployment that the manager Deployments leave empty.
+func setServerDefaults(deployment *appsv1.Deployment) {
+	maxUnavailable := intstr.FromString("25%")
+	maxSurge := intstr.FromString("25%")
+	deployment.Spec.Strategy = appsv1.DeploymentStrategy{
+		Type:          appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{MaxUnavailable: &maxUnavailable, MaxSurge: &maxSurge},
+	}
+	deployment.Spec.RevisionHistoryLimit = k8sutilspointer.Int32Ptr(10)
+	deployment.Spec.ProgressDeadlineSeconds = k8sutilspointer.Int32Ptr(600)
+
+	podSpec := &deployment.Spec.Template.Spec
+	podSpec.RestartPolicy = corev1.RestartPolicyAlways
+	podSpec.DNSPolicy = corev1.DNSClusterFirst
+	podSpec.SchedulerName = corev1.DefaultSchedulerName
+	podSpec.SecurityContext = &corev1.PodSecurityContext{}
+	podSpec.DeprecatedServiceAccount = podSpec.ServiceAccountName
+	if podSpec.TerminationGracePeriodSeconds == nil {
+		podSpec.TerminationGracePeriodSeconds = k8sutilspointer.Int64Ptr(30)
+	}
+	for i := range podSpec.Volumes {
+		if secret := podSpec.Volumes[i].Secret; secret != nil && secret.DefaultMode == nil {
+			secret.DefaultMode = k8sutilspointer.Int32Ptr(0644)
+		}
+		if projected := podSpec.Volumes[i].Projected; projected != nil && projected.DefaultMode == nil {
+			projected.DefaultMode = k8sutilspointer.Int32Ptr(0644)
+		}
+	}
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		container.TerminationMessagePath = corev1.TerminationMessagePathDefault
+		container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	}
+}
+
+func TestCreateOrUpdateManagerDeploymentDefaulted(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	recorder := record.NewFakeRecorder(10)
+	r := &CAPIDeploymentReconciler{
+		Client:   newFakeClient(),
+		Scheme:   testScheme,
+		Recorder: recorder,
+	}
+	capiDeployment := &operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "cluster"}}
+
+	for _, tc := range []struct {
+		deployment *appsv1.Deployment
+		reconcile  func(*appsv1.Deployment) error
+	}{
+		{
+			deployment: ClusterAPIManagerDeployment("test"),
+			reconcile: func(deployment *appsv1.Deployment) error {
+				return reconcileCAPIManagerDeployment(deployment, operatorv1.ProviderSpec{})
+			},
+		},
+		{
+			deployment: ClusterAPIAWSManagerDeployment("test"),
+			reconcile: func(deployment *appsv1.Deployment) error {
+				return reconcileCAPIAWSProviderDeployment(deployment, operatorv1.ProviderSpec{}, nil, true)
+			},
+		},
+	} {
+		deployment := tc.deployment
+		_, err := r.createOrUpdate(ctx, capiDeployment, deployment, func() error {
+			return tc.reconcile(deployment)
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(recorder.Events).To(Receive(Equal("Normal Created Created Deployment " + deployment.Name)))
+
+		setServerDefaults(deployment)
+		g.Expect(r.Client.Update(ctx, deployment)).To(Succeed())
+
+		// Reconciling again must not undo the defaults.
+		result, err := r.createOrUpdate(ctx, capiDeployment, deployment, func() error {
+			return tc.reconcile(deployment)
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(result).To(Equal(controllerutil.OperationResultNone))
+		g.Expect(recorder.Events).NotTo(Receive())
+
+		// A changed field the operator owns is still applied.
+		result, err = r.createOrUpdate(ctx, capiDeployment, deployment, func() error {
+			if err := tc.reconcile(deployment); err != nil {
+				return err
+			}
+			deployment.Spec.Replicas = k8sutilspointer.Int32Ptr(0)
+			return nil
+		})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(result).To(Equal(controllerutil.OperationResultUpdated))
+		g.Expect(recorder.Events).To(Receive(Equal("Normal Updated Updated Deployment " + deployment.Name + ": changed spec.replicas")))
+	}
+}
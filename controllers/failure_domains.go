@@ -0,0 +1,93 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// machineAPINamespace holds the Machines of the OpenShift Machine API.
+	machineAPINamespace = "openshift-machine-api"
+
+	machineZoneLabel = "machine.openshift.io/zone"
+	machineRoleLabel = "machine.openshift.io/cluster-api-machine-role"
+)
+
+var (
+	machineGVK     = schema.GroupVersionKind{Group: "machine.openshift.io", Version: "v1beta1", Kind: "Machine"}
+	machineListGVK = schema.GroupVersionKind{Group: "machine.openshift.io", Version: "v1beta1", Kind: "MachineList"}
+)
+
+// +kubebuilder:rbac:groups=machine.openshift.io,resources=machines,verbs=get;list;watch
+
+// specFailureDomains converts the failure domains set on a CAPIDeployment.
+func specFailureDomains(failureDomains []operatorv1.FailureDomain) clusterv1.FailureDomains {
+	out := clusterv1.FailureDomains{}
+	for _, fd := range failureDomains {
+		out[fd.Name] = clusterv1.FailureDomainSpec{ControlPlane: fd.ControlPlane}
+	}
+	return out
+}
+
+// machineFailureDomains returns a failure domain for each zone used by the
+// cluster's Machines. Zones hosting control plane Machines accept control
+// plane Machines.
+func machineFailureDomains(machines []unstructured.Unstructured) clusterv1.FailureDomains {
+	out := clusterv1.FailureDomains{}
+	for i := range machines {
+		zone, controlPlane := machineFailureDomain(&machines[i])
+		if zone == "" {
+			continue
+		}
+
+		fd := out[zone]
+		if controlPlane {
+			fd.ControlPlane = true
+		}
+		out[zone] = fd
+	}
+	return out
+}
+
+// machineFailureDomain returns the zone of a Machine and whether it is a
+// control plane Machine.
+func machineFailureDomain(machine *unstructured.Unstructured) (string, bool) {
+	zone := machine.GetLabels()[machineZoneLabel]
+	if zone == "" {
+		zone, _, _ = unstructured.NestedString(machine.Object, "spec", "providerSpec", "value", "placement", "availabilityZone")
+	}
+	return zone, machine.GetLabels()[machineRoleLabel] == "master"
+}
+
+// listMachineFailureDomains derives failure domains from the Machines of the
+// OpenShift Machine API.
+func listMachineFailureDomains(ctx context.Context, c client.Client) (clusterv1.FailureDomains, error) {
+	machines := &unstructured.UnstructuredList{}
+	machines.SetGroupVersionKind(machineListGVK)
+	if err := c.List(ctx, machines, client.InNamespace(machineAPINamespace)); err != nil {
+		return nil, fmt.Errorf("failed to list machines: %w", err)
+	}
+	return machineFailureDomains(machines.Items), nil
+}
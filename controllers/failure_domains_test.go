@@ -0,0 +1,54 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+)
+
+func testMachine(role, zoneLabel, placementZone string) unstructured.Unstructured {
+	machine := unstructured.Unstructured{Object: map[string]interface{}{}}
+	labels := map[string]string{machineRoleLabel: role}
+	if zoneLabel != "" {
+		labels[machineZoneLabel] = zoneLabel
+	}
+	machine.SetLabels(labels)
+	if placementZone != "" {
+		_ = unstructured.SetNestedField(machine.Object, placementZone, "spec", "providerSpec", "value", "placement", "availabilityZone")
+	}
+	return machine
+}
+
+func TestMachineFailureDomains(t *testing.T) {
+	g := NewWithT(t)
+
+	failureDomains := machineFailureDomains([]unstructured.Unstructured{
+		testMachine("master", "us-east-1a", ""),
+		testMachine("worker", "us-east-1a", ""),
+		testMachine("worker", "", "us-east-1b"),
+		testMachine("worker", "", ""),
+	})
+
+	g.Expect(failureDomains).To(Equal(clusterv1.FailureDomains{
+		"us-east-1a": clusterv1.FailureDomainSpec{ControlPlane: true},
+		"us-east-1b": clusterv1.FailureDomainSpec{},
+	}))
+}
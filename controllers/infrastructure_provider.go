@@ -0,0 +1,488 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	configv1 "github.com/openshift/api/config/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// cloudConfigNamespace holds the cloud provider config referenced by the
+	// Infrastructure object.
+	cloudConfigNamespace = "openshift-config"
+
+	// infrastructureAPIVersion is the API version of the infrastructure
+	// cluster kinds of providers without vendored Go types.
+	infrastructureAPIVersion = "infrastructure.cluster.x-k8s.io/v1alpha3"
+
+	defaultAPIServerPort = 6443
+
+	// cloudCredentialOperatorNamespace holds the CredentialsRequests served
+	// by the cloud credential operator.
+	cloudCredentialOperatorNamespace = "openshift-cloud-credential-operator"
+
+	credentialsRequestAPIVersion = "cloudcredential.openshift.io/v1"
+
+	// cloudCredentialsNamespace holds the cloud credentials of the cluster
+	// that provider credentials are copied from.
+	cloudCredentialsNamespace = "kube-system"
+
+	// credentialsModeManual is the cloud credential mode in which the cloud
+	// credential operator does not provision credentials itself.
+	credentialsModeManual = "Manual"
+)
+
+// cloudCredentialGVK is the operator configuration of the cloud credential
+// operator, which holds the cluster credentials mode.
+var cloudCredentialGVK = schema.GroupVersionKind{Group: "operator.openshift.io", Version: "v1", Kind: "CloudCredential"}
+
+// errPlatformConfigInvalid is wrapped by ValidatePlatformStatus errors about
+// platform configuration that is present but malformed.
+var errPlatformConfigInvalid = errors.New("invalid platform configuration")
+
+// errCredentialsNotReady is wrapped by ReconcileCredentials errors about
+// source credentials that do not exist yet.
+var errCredentialsNotReady = errors.New("credentials not ready")
+
+// infrastructureProvider deploys a Cluster API infrastructure provider and
+// its infrastructure cluster object for one OpenShift platform type. The core
+// reconcile loop only talks to providers through this interface, so a new
+// platform is added by implementing it and registering the implementation in
+// infrastructureProviders.
+type infrastructureProvider interface {
+	// ValidatePlatformStatus checks that the Infrastructure object carries
+	// everything the provider needs.
+	ValidatePlatformStatus(infra *clusterInfrastructure) error
+
+	// InfrastructureCluster returns the infrastructure cluster object
+	// referenced by the CAPI Cluster, with only its identity set.
+	InfrastructureCluster(name, namespace string) controllerutil.Object
+
+	// ReconcileInfrastructureCluster sets the desired state of the
+	// infrastructure cluster from the Infrastructure object.
+	ReconcileInfrastructureCluster(infraCluster controllerutil.Object, infra *clusterInfrastructure) error
+
+	// ReconcileInfrastructureClusterStatus reports the installer-provisioned
+	// infrastructure on the infrastructure cluster status.
+	ReconcileInfrastructureClusterStatus(ctx context.Context, c client.Client, infraCluster controllerutil.Object) error
+
+	// InfrastructureClusterReady reports whether the infrastructure cluster
+	// has been marked ready.
+	InfrastructureClusterReady(infraCluster controllerutil.Object) (bool, error)
+
+	// ManagerDeployment returns the provider manager Deployment, with only
+	// its identity set.
+	ManagerDeployment(namespace string) *appsv1.Deployment
+
+	// ReconcileManagerDeployment sets the desired state of the provider
+	// manager Deployment.
+	ReconcileManagerDeployment(deployment *appsv1.Deployment, provider operatorv1.ProviderSpec, infra *clusterInfrastructure) error
+
+	// ManagerRBAC describes the identity and permissions of the provider
+	// manager.
+	ManagerRBAC() managerRBAC
+
+	// ReconcileCredentials makes the cloud credentials used by the provider
+	// manager available in the namespace of the CAPIDeployment, which owns
+	// the secrets it creates.
+	ReconcileCredentials(ctx context.Context, c *eventingClient, capiDeployment *operatorv1.CAPIDeployment, infra *clusterInfrastructure) error
+
+	// DeleteCredentials removes the credentials created by
+	// ReconcileCredentials.
+	DeleteCredentials(ctx context.Context, c client.Client, namespace string) error
+}
+
+// infrastructureProviders maps each supported platform to its provider.
+var infrastructureProviders = map[configv1.PlatformType]infrastructureProvider{
+	configv1.AWSPlatformType:       &awsProvider{},
+	configv1.AzurePlatformType:     &azureProvider{},
+	configv1.GCPPlatformType:       &gcpProvider{},
+	configv1.OpenStackPlatformType: &openstackProvider{},
+	configv1.VSpherePlatformType:   &vsphereProvider{},
+}
+
+// allInfrastructureProviders returns every supported provider, ordered by
+// platform.
+func allInfrastructureProviders() []infrastructureProvider {
+	platforms := make([]string, 0, len(infrastructureProviders))
+	for platform := range infrastructureProviders {
+		platforms = append(platforms, string(platform))
+	}
+	sort.Strings(platforms)
+
+	providers := make([]infrastructureProvider, 0, len(platforms))
+	for _, platform := range platforms {
+		providers = append(providers, infrastructureProviders[configv1.PlatformType(platform)])
+	}
+	return providers
+}
+
+// getInfrastructureProvider returns the provider for the platform the cluster
+// runs on. Older clusters only set the deprecated Status.Platform.
+func getInfrastructureProvider(infra *clusterInfrastructure) (infrastructureProvider, error) {
+	platform := infra.Status.Platform
+	if infra.Status.PlatformStatus != nil && infra.Status.PlatformStatus.Type != "" {
+		platform = infra.Status.PlatformStatus.Type
+	}
+
+	provider, ok := infrastructureProviders[platform]
+	if !ok {
+		return nil, fmt.Errorf("platform %q is not supported", platform)
+	}
+
+	return provider, nil
+}
+
+// clusterInfrastructure is the cluster Infrastructure object together with
+// the data providers need that the vendored config/v1 API does not carry.
+type clusterInfrastructure struct {
+	*configv1.Infrastructure
+
+	// CloudConfig is the cloud provider config referenced by
+	// Spec.CloudConfig, empty on platforms that have none.
+	CloudConfig string
+
+	// AzureCloudName is status.platformStatus.azure.cloudName.
+	AzureCloudName string
+
+	// AzureControlPlaneSubnet is platform.azure.controlPlaneSubnet of the
+	// install config, only set for clusters installed into an existing
+	// virtual network.
+	AzureControlPlaneSubnet string
+
+	// AWSResourceTags is status.platformStatus.aws.resourceTags, the
+	// user-defined tags applied to all AWS resources of the cluster.
+	AWSResourceTags map[string]string
+
+	// AWSServiceEndpoints is status.platformStatus.aws.serviceEndpoints,
+	// custom endpoint URLs keyed by AWS service name.
+	AWSServiceEndpoints map[string]string
+
+	// CredentialsMode is the spec.credentialsMode of the cluster
+	// CloudCredential object, empty for the default mode.
+	CredentialsMode string
+
+	// ServiceAccountIssuer is the spec.serviceAccountIssuer of the cluster
+	// Authentication object. Together with the Manual credentials mode it
+	// means cloud credentials are short-lived tokens.
+	ServiceAccountIssuer string
+}
+
+// shortLivedCredentials reports whether the cluster uses the Manual
+// credentials mode with a custom service account issuer, in which case
+// providers authenticate with projected service account tokens.
+func (infra *clusterInfrastructure) shortLivedCredentials() bool {
+	return infra.CredentialsMode == credentialsModeManual && infra.ServiceAccountIssuer != ""
+}
+
+// getClusterInfrastructure reads the Infrastructure object. It is read
+// unstructured so that fields newer than the vendored API are not dropped.
+func getClusterInfrastructure(ctx context.Context, c client.Client) (*clusterInfrastructure, error) {
+	raw := &unstructured.Unstructured{}
+	raw.SetGroupVersionKind(configv1.GroupVersion.WithKind("Infrastructure"))
+	if err := c.Get(ctx, types.NamespacedName{Name: globalInfrastuctureName}, raw); err != nil {
+		return nil, err
+	}
+
+	infra := &clusterInfrastructure{Infrastructure: &configv1.Infrastructure{}}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw.Object, infra.Infrastructure); err != nil {
+		return nil, fmt.Errorf("failed to convert infrastructure object: %w", err)
+	}
+
+	infra.AzureCloudName, _, _ = unstructured.NestedString(raw.Object, "status", "platformStatus", "azure", "cloudName")
+
+	resourceTags, _, _ := unstructured.NestedSlice(raw.Object, "status", "platformStatus", "aws", "resourceTags")
+	for _, resourceTag := range resourceTags {
+		tag, ok := resourceTag.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key, _, _ := unstructured.NestedString(tag, "key")
+		value, _, _ := unstructured.NestedString(tag, "value")
+		if key == "" {
+			continue
+		}
+		if infra.AWSResourceTags == nil {
+			infra.AWSResourceTags = map[string]string{}
+		}
+		infra.AWSResourceTags[key] = value
+	}
+
+	serviceEndpoints, _, _ := unstructured.NestedSlice(raw.Object, "status", "platformStatus", "aws", "serviceEndpoints")
+	for _, serviceEndpoint := range serviceEndpoints {
+		endpoint, ok := serviceEndpoint.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(endpoint, "name")
+		endpointURL, _, _ := unstructured.NestedString(endpoint, "url")
+		if infra.AWSServiceEndpoints == nil {
+			infra.AWSServiceEndpoints = map[string]string{}
+		}
+		infra.AWSServiceEndpoints[name] = endpointURL
+	}
+
+	var err error
+	infra.CredentialsMode, err = getClusterConfigString(ctx, c, cloudCredentialGVK, "spec", "credentialsMode")
+	if err != nil {
+		return nil, err
+	}
+	infra.ServiceAccountIssuer, err = getClusterConfigString(ctx, c, configv1.GroupVersion.WithKind("Authentication"), "spec", "serviceAccountIssuer")
+	if err != nil {
+		return nil, err
+	}
+
+	if infra.Status.PlatformStatus != nil && infra.Status.PlatformStatus.Azure != nil {
+		infra.AzureControlPlaneSubnet, err = getAzureControlPlaneSubnet(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ref := infra.Spec.CloudConfig; ref.Name != "" {
+		cloudConfig := &corev1.ConfigMap{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: cloudConfigNamespace, Name: ref.Name}, cloudConfig); err != nil {
+			return nil, fmt.Errorf("failed to get cloud provider config: %w", err)
+		}
+		infra.CloudConfig = cloudConfig.Data[ref.Key]
+	}
+
+	return infra, nil
+}
+
+// getClusterConfigString reads a string field of a cluster-wide singleton
+// object, which may not exist on older clusters.
+func getClusterConfigString(ctx context.Context, c client.Client, gvk schema.GroupVersionKind, fields ...string) (string, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	if err := c.Get(ctx, types.NamespacedName{Name: globalInfrastuctureName}, obj); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get %s object: %w", gvk.Kind, err)
+	}
+
+	value, _, err := unstructured.NestedString(obj.Object, fields...)
+	return value, err
+}
+
+// newInfrastructureCluster returns an infrastructure cluster of a provider
+// without vendored Go types, marked as managed outside of Cluster API.
+func newInfrastructureCluster(kind, name, namespace string) *unstructured.Unstructured {
+	infraCluster := &unstructured.Unstructured{}
+	infraCluster.SetAPIVersion(infrastructureAPIVersion)
+	infraCluster.SetKind(kind)
+	infraCluster.SetNamespace(namespace)
+	infraCluster.SetName(name)
+	infraCluster.SetAnnotations(map[string]string{managedByAnnotation: managedByValue})
+	return infraCluster
+}
+
+// setInfrastructureClusterSpec replaces the spec of an unstructured
+// infrastructure cluster, keeping it marked as externally managed.
+func setInfrastructureClusterSpec(infraCluster controllerutil.Object, spec map[string]interface{}) error {
+	u, ok := infraCluster.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("expected unstructured infrastructure cluster, got %T", infraCluster)
+	}
+
+	annotations := u.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[managedByAnnotation] = managedByValue
+	u.SetAnnotations(annotations)
+
+	return unstructured.SetNestedMap(u.Object, spec, "spec")
+}
+
+// markInfrastructureClusterReady sets status.ready on an unstructured
+// infrastructure cluster. The infrastructure was provisioned by the
+// installer, so there is nothing to wait for once the object exists.
+func markInfrastructureClusterReady(ctx context.Context, c client.Client, infraCluster controllerutil.Object) error {
+	u, ok := infraCluster.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("expected unstructured infrastructure cluster, got %T", infraCluster)
+	}
+
+	if ready, _, _ := unstructured.NestedBool(u.Object, "status", "ready"); ready {
+		return nil
+	}
+
+	patchBase := client.MergeFrom(u.DeepCopy())
+	if err := unstructured.SetNestedField(u.Object, true, "status", "ready"); err != nil {
+		return err
+	}
+
+	return c.Status().Patch(ctx, u, patchBase)
+}
+
+// unstructuredInfrastructureClusterReady reads status.ready of an
+// unstructured infrastructure cluster.
+func unstructuredInfrastructureClusterReady(infraCluster controllerutil.Object) (bool, error) {
+	u, ok := infraCluster.(*unstructured.Unstructured)
+	if !ok {
+		return false, fmt.Errorf("expected unstructured infrastructure cluster, got %T", infraCluster)
+	}
+
+	ready, _, err := unstructured.NestedBool(u.Object, "status", "ready")
+	return ready, err
+}
+
+// uncachedSecretsClient returns a client that reads through apiReader, for
+// the secrets the operator does not cache, and writes through c.
+func uncachedSecretsClient(c client.Client, apiReader client.Reader) client.Client {
+	return &client.DelegatingClient{Reader: apiReader, Writer: c, StatusClient: c}
+}
+
+// syncCredentialsSecret copies the cluster's cloud credentials into the
+// secret read by a provider manager. keys maps source keys to target keys.
+func syncCredentialsSecret(ctx context.Context, c *eventingClient, owner *operatorv1.CAPIDeployment, source types.NamespacedName, target *corev1.Secret, keys map[string]string) error {
+	return renderCredentialsSecret(ctx, c, owner, source, target, func(data map[string][]byte) (map[string][]byte, error) {
+		rendered := map[string][]byte{}
+		for sourceKey, targetKey := range keys {
+			value, ok := data[sourceKey]
+			if !ok {
+				return nil, fmt.Errorf("credentials secret %s has no %q key", source, sourceKey)
+			}
+			rendered[targetKey] = value
+		}
+		return rendered, nil
+	})
+}
+
+// renderCredentialsSecret writes the data rendered from the cluster's cloud
+// credentials into the secret read by a provider manager.
+func renderCredentialsSecret(ctx context.Context, c *eventingClient, owner *operatorv1.CAPIDeployment, source types.NamespacedName, target *corev1.Secret, render func(map[string][]byte) (map[string][]byte, error)) error {
+	sourceSecret := &corev1.Secret{}
+	if err := c.Get(ctx, source, sourceSecret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("%w: credentials secret %s does not exist", errCredentialsNotReady, source)
+		}
+		return fmt.Errorf("failed to get credentials secret %s: %w", source, err)
+	}
+
+	data, err := render(sourceSecret.Data)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.createOrUpdate(ctx, target, func() error {
+		setControllerReference(owner, target)
+		target.Type = corev1.SecretTypeOpaque
+		target.Data = data
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reconcile credentials secret %s: %w", target.Name, err)
+	}
+
+	return nil
+}
+
+// setControllerReference makes a CAPIDeployment the controller of one of its
+// namespaced children. Providers have no scheme to resolve the owner kind
+// from, unlike controllerutil.SetControllerReference.
+func setControllerReference(owner *operatorv1.CAPIDeployment, obj metav1.Object) {
+	ref := *metav1.NewControllerRef(owner, operatorv1.GroupVersion.WithKind("CAPIDeployment"))
+
+	refs := obj.GetOwnerReferences()
+	for i := range refs {
+		if refs[i].UID == ref.UID {
+			refs[i] = ref
+			obj.SetOwnerReferences(refs)
+			return
+		}
+	}
+	obj.SetOwnerReferences(append(refs, ref))
+}
+
+// getControlPlaneEndpoint returns the API server endpoint of the cluster. The
+// internal URL is preferred, it is the one nodes use to reach the API.
+func getControlPlaneEndpoint(infra *clusterInfrastructure) (clusterv1.APIEndpoint, error) {
+	apiServerURL := infra.Status.APIServerInternalURL
+	if apiServerURL == "" {
+		apiServerURL = infra.Status.APIServerURL
+	}
+	if apiServerURL == "" {
+		return clusterv1.APIEndpoint{}, fmt.Errorf("infrastructure %q has no API server URL", infra.Name)
+	}
+
+	host, port, err := apiServerEndpoint(apiServerURL)
+	if err != nil {
+		return clusterv1.APIEndpoint{}, err
+	}
+
+	return clusterv1.APIEndpoint{Host: host, Port: port}, nil
+}
+
+// deleteCredentialsSecret removes a credentials secret created for a
+// provider manager.
+func deleteCredentialsSecret(ctx context.Context, c client.Client, namespace, name string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+	}
+	if err := c.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete credentials secret %s: %w", name, err)
+	}
+	return nil
+}
+
+// apiServerEndpoint splits an API server URL into host and port. The port
+// defaults to 6443, the port OpenShift serves the API on.
+func apiServerEndpoint(apiServerURL string) (string, int32, error) {
+	u, err := url.Parse(apiServerURL)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to parse API server URL %q: %w", apiServerURL, err)
+	}
+	if u.Hostname() == "" {
+		return "", 0, fmt.Errorf("API server URL %q has no host", apiServerURL)
+	}
+
+	port := int64(defaultAPIServerPort)
+	if u.Port() != "" {
+		port, err = strconv.ParseInt(u.Port(), 10, 32)
+		if err != nil {
+			return "", 0, fmt.Errorf("API server URL %q has an invalid port: %w", apiServerURL, err)
+		}
+	}
+
+	return u.Hostname(), int32(port), nil
+}
@@ -0,0 +1,122 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+)
+
+func TestGetControlPlaneEndpoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      configv1.InfrastructureStatus
+		expected    clusterv1.APIEndpoint
+		expectError bool
+	}{
+		{
+			name: "internal URL preferred",
+			status: configv1.InfrastructureStatus{
+				APIServerURL:         "https://api.test.example.com:6443",
+				APIServerInternalURL: "https://api-int.test.example.com:6443",
+			},
+			expected: clusterv1.APIEndpoint{Host: "api-int.test.example.com", Port: 6443},
+		},
+		{
+			name:     "external URL fallback",
+			status:   configv1.InfrastructureStatus{APIServerURL: "https://api.test.example.com:443"},
+			expected: clusterv1.APIEndpoint{Host: "api.test.example.com", Port: 443},
+		},
+		{
+			name:     "default port",
+			status:   configv1.InfrastructureStatus{APIServerURL: "https://api.test.example.com"},
+			expected: clusterv1.APIEndpoint{Host: "api.test.example.com", Port: 6443},
+		},
+		{
+			name:        "no URL",
+			expectError: true,
+		},
+		{
+			name:        "invalid port",
+			status:      configv1.InfrastructureStatus{APIServerURL: "https://api.test.example.com:api"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			infra := &clusterInfrastructure{Infrastructure: &configv1.Infrastructure{Status: tt.status}}
+			endpoint, err := getControlPlaneEndpoint(infra)
+			if tt.expectError {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(endpoint).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestInfrastructureClusterControlPlaneEndpoint(t *testing.T) {
+	infra := &clusterInfrastructure{
+		Infrastructure: &configv1.Infrastructure{
+			Status: configv1.InfrastructureStatus{
+				InfrastructureName:   "test-abcde",
+				APIServerURL:         "https://api.test.example.com:6443",
+				APIServerInternalURL: "https://api-int.test.example.com:6443",
+				PlatformStatus: &configv1.PlatformStatus{
+					VSphere:   &configv1.VSpherePlatformStatus{APIServerInternalIP: "192.168.1.10"},
+					OpenStack: &configv1.OpenStackPlatformStatus{APIServerInternalIP: "10.0.0.5"},
+				},
+			},
+		},
+		CloudConfig: "[Workspace]\nserver = vcenter.example.com\n",
+	}
+	clusterEndpoint, err := getControlPlaneEndpoint(infra)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The infrastructure cluster and the Cluster agree on the endpoint, the
+	// API VIPs are not used.
+	for _, tt := range []struct {
+		name     string
+		provider infrastructureProvider
+	}{
+		{name: "vsphere", provider: &vsphereProvider{}},
+		{name: "openstack", provider: &openstackProvider{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			infraCluster := tt.provider.InfrastructureCluster("cluster", "openshift-cluster-api")
+			g.Expect(tt.provider.ReconcileInfrastructureCluster(infraCluster, infra)).To(Succeed())
+
+			u := infraCluster.(*unstructured.Unstructured)
+			host, _, _ := unstructured.NestedString(u.Object, "spec", "controlPlaneEndpoint", "host")
+			port, _, _ := unstructured.NestedInt64(u.Object, "spec", "controlPlaneEndpoint", "port")
+			g.Expect(host).To(Equal(clusterEndpoint.Host))
+			g.Expect(port).To(BeEquivalentTo(clusterEndpoint.Port))
+		})
+	}
+}
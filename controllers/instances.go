@@ -0,0 +1,57 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// A CAPIDeployment deploys managers with fixed names into its own namespace,
+// so there can be only one per namespace: the oldest is admitted and any
+// later one is reported as a duplicate and left alone. Everything cluster
+// scoped is named after the namespace, so CAPIDeployments in different
+// namespaces do not interfere.
+
+// admittedCAPIDeployment returns the CAPIDeployment admitted in the
+// namespace of capiDeployment.
+func (r *CAPIDeploymentReconciler) admittedCAPIDeployment(ctx context.Context, capiDeployment *operatorv1.CAPIDeployment) (*operatorv1.CAPIDeployment, error) {
+	capiDeployments := &operatorv1.CAPIDeploymentList{}
+	if err := r.Client.List(ctx, capiDeployments, client.InNamespace(capiDeployment.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list CAPIDeployments: %w", err)
+	}
+
+	admitted := capiDeployment
+	for i := range capiDeployments.Items {
+		if olderCAPIDeployment(&capiDeployments.Items[i], admitted) {
+			admitted = &capiDeployments.Items[i]
+		}
+	}
+	return admitted, nil
+}
+
+// olderCAPIDeployment orders CAPIDeployments by creation, breaking ties by
+// name so every reconcile agrees on which one is admitted.
+func olderCAPIDeployment(a, b *operatorv1.CAPIDeployment) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Name < b.Name
+}
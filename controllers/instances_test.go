@@ -0,0 +1,60 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAdmittedCAPIDeployment(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	created := metav1.NewTime(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	capiDeployment := func(namespace, name string, creationTimestamp metav1.Time) *operatorv1.CAPIDeployment {
+		return &operatorv1.CAPIDeployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, CreationTimestamp: creationTimestamp},
+		}
+	}
+	first := capiDeployment("openshift-cluster-api", "b", created)
+	sameTime := capiDeployment("openshift-cluster-api", "c", created)
+	later := capiDeployment("openshift-cluster-api", "a", metav1.NewTime(created.Add(time.Hour)))
+	// CAPIDeployments in other namespaces are independent.
+	otherNamespace := capiDeployment("other", "a", metav1.NewTime(created.Add(-time.Hour)))
+
+	r := &CAPIDeploymentReconciler{
+		Client: newFakeClient(first, sameTime, later, otherNamespace),
+		Scheme: testScheme,
+	}
+
+	for _, capiDeployment := range []*operatorv1.CAPIDeployment{first, sameTime, later} {
+		admitted, err := r.admittedCAPIDeployment(ctx, capiDeployment)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(admitted.Name).To(Equal(first.Name))
+	}
+
+	admitted, err := r.admittedCAPIDeployment(ctx, otherNamespace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(admitted.Name).To(Equal(otherNamespace.Name))
+	g.Expect(admitted.Namespace).To(Equal(otherNamespace.Namespace))
+}
@@ -0,0 +1,145 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Components of a CAPIDeployment whose reconciles are measured, besides the
+// manager Deployments, which are measured by name.
+const (
+	clusterComponent               = "cluster"
+	infrastructureClusterComponent = "infrastructure_cluster"
+	credentialsComponent           = "credentials"
+	rbacComponent                  = "rbac"
+)
+
+var (
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capi_operator_reconcile_errors_total",
+		Help: "Number of failed reconciles of a component of a CAPIDeployment.",
+	}, []string{"namespace", "component"})
+
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "capi_operator_reconcile_duration_seconds",
+		Help: "Time taken to reconcile a component of a CAPIDeployment.",
+	}, []string{"namespace", "component"})
+
+	providerReadyReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "capi_operator_provider_ready_replicas",
+		Help: "Number of available replicas of a provider manager Deployment.",
+	}, []string{"namespace", "deployment"})
+
+	providerInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "capi_operator_provider_info",
+		Help: "Provider version and image deployed by a CAPIDeployment, always 1.",
+	}, []string{"namespace", "deployment", "version", "image"})
+
+	capiDeploymentsNotAvailable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "capi_operator_capideployments_not_available",
+		Help: "Number of CAPIDeployments whose Available condition is not true.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		reconcileErrors,
+		reconcileDuration,
+		providerReadyReplicas,
+		providerInfo,
+		capiDeploymentsNotAvailable,
+	)
+}
+
+// observeReconcile records the duration and outcome of reconciling a
+// component that started at start. Waiting for credentials that have not been
+// minted yet is not counted as an error.
+func observeReconcile(namespace, component string, start time.Time, err error) {
+	reconcileDuration.WithLabelValues(namespace, component).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, errCredentialsNotReady) {
+		reconcileErrors.WithLabelValues(namespace, component).Inc()
+	}
+}
+
+// providerInfoLabels remembers the version and image last reported for each
+// Deployment, so that the stale series is removed when they change.
+var providerInfoLabels = struct {
+	sync.Mutex
+	labels map[string][]string
+}{labels: map[string][]string{}}
+
+// setProviderInfo records the version and image of a provider manager
+// Deployment.
+func setProviderInfo(deployment *appsv1.Deployment, version string) {
+	var image string
+	if containers := deployment.Spec.Template.Spec.Containers; len(containers) > 0 {
+		image = containers[0].Image
+	}
+	labels := []string{deployment.Namespace, deployment.Name, version, image}
+
+	providerInfoLabels.Lock()
+	defer providerInfoLabels.Unlock()
+	key := deployment.Namespace + "/" + deployment.Name
+	if previous, ok := providerInfoLabels.labels[key]; ok {
+		providerInfo.DeleteLabelValues(previous...)
+	}
+	providerInfo.WithLabelValues(labels...).Set(1)
+	providerInfoLabels.labels[key] = labels
+}
+
+// deleteProviderMetrics removes the series of a deleted provider manager
+// Deployment.
+func deleteProviderMetrics(namespace, name string) {
+	providerReadyReplicas.DeleteLabelValues(namespace, name)
+
+	providerInfoLabels.Lock()
+	defer providerInfoLabels.Unlock()
+	key := namespace + "/" + name
+	if previous, ok := providerInfoLabels.labels[key]; ok {
+		providerInfo.DeleteLabelValues(previous...)
+		delete(providerInfoLabels.labels, key)
+	}
+}
+
+// notAvailableCAPIDeployments holds the CAPIDeployments counted by
+// capiDeploymentsNotAvailable.
+var notAvailableCAPIDeployments = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+// setCAPIDeploymentAvailable records whether a CAPIDeployment is available
+// after a reconcile. Deleted CAPIDeployments are recorded as available so
+// they are no longer counted.
+func setCAPIDeploymentAvailable(namespace, name string, available bool) {
+	notAvailableCAPIDeployments.Lock()
+	defer notAvailableCAPIDeployments.Unlock()
+	key := namespace + "/" + name
+	if available {
+		delete(notAvailableCAPIDeployments.names, key)
+	} else {
+		notAvailableCAPIDeployments.names[key] = true
+	}
+	capiDeploymentsNotAvailable.Set(float64(len(notAvailableCAPIDeployments.names)))
+}
@@ -0,0 +1,83 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetProviderInfo(t *testing.T) {
+	g := NewWithT(t)
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-metrics", Name: "capa-controller-manager"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "manager", Image: "capa:v0.6.4"}},
+				},
+			},
+		},
+	}
+	setProviderInfo(deployment, "v0.6.4")
+	g.Expect(testutil.ToFloat64(providerInfo.WithLabelValues("test-metrics", "capa-controller-manager", "v0.6.4", "capa:v0.6.4"))).To(Equal(float64(1)))
+
+	// An upgrade replaces the series of the previous version.
+	deployment.Spec.Template.Spec.Containers[0].Image = "capa:v0.6.5"
+	setProviderInfo(deployment, "v0.6.5")
+	g.Expect(providerInfo.DeleteLabelValues("test-metrics", "capa-controller-manager", "v0.6.4", "capa:v0.6.4")).To(BeFalse())
+	g.Expect(testutil.ToFloat64(providerInfo.WithLabelValues("test-metrics", "capa-controller-manager", "v0.6.5", "capa:v0.6.5"))).To(Equal(float64(1)))
+
+	deleteProviderMetrics("test-metrics", "capa-controller-manager")
+	g.Expect(providerInfo.DeleteLabelValues("test-metrics", "capa-controller-manager", "v0.6.5", "capa:v0.6.5")).To(BeFalse())
+}
+
+func TestSetCAPIDeploymentAvailable(t *testing.T) {
+	g := NewWithT(t)
+
+	setCAPIDeploymentAvailable("test-metrics", "a", false)
+	setCAPIDeploymentAvailable("test-metrics", "b", false)
+	setCAPIDeploymentAvailable("test-metrics", "b", false)
+	g.Expect(testutil.ToFloat64(capiDeploymentsNotAvailable)).To(Equal(float64(2)))
+
+	setCAPIDeploymentAvailable("test-metrics", "a", true)
+	g.Expect(testutil.ToFloat64(capiDeploymentsNotAvailable)).To(Equal(float64(1)))
+
+	setCAPIDeploymentAvailable("test-metrics", "b", true)
+	g.Expect(testutil.ToFloat64(capiDeploymentsNotAvailable)).To(BeZero())
+}
+
+func TestObserveReconcileCredentialsNotReady(t *testing.T) {
+	g := NewWithT(t)
+
+	counter := reconcileErrors.WithLabelValues("test-metrics", credentialsComponent)
+
+	observeReconcile("test-metrics", credentialsComponent, time.Now(), fmt.Errorf("%w: credentials secret %s does not exist", errCredentialsNotReady, "openshift-cluster-api/capa-cloud-credentials"))
+	g.Expect(testutil.ToFloat64(counter)).To(BeZero())
+
+	observeReconcile("test-metrics", credentialsComponent, time.Now(), errors.New("failed to get credentials"))
+	g.Expect(testutil.ToFloat64(counter)).To(Equal(float64(1)))
+}
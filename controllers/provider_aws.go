@@ -0,0 +1,585 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	configv1 "github.com/openshift/api/config/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/api/v1alpha3"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// capaCredentialsSecretName is the secret holding the shared credentials
+	// file mounted into the CAPA manager.
+	capaCredentialsSecretName = "capa-manager-bootstrap-credentials"
+
+	// capaCloudCredentialsSecretName is the secret the cloud credential
+	// operator writes the credentials requested for CAPA to.
+	capaCloudCredentialsSecretName = "capa-cloud-credentials"
+
+	// capaTokenMountPath is where the projected service account token used
+	// for short-lived credentials is mounted.
+	capaTokenMountPath = "/var/run/secrets/openshift/serviceaccount"
+
+	// capaTokenAudience is the audience of the projected service account
+	// token, matching the audience of the cluster's IAM OIDC provider.
+	capaTokenAudience = "openshift"
+)
+
+// +kubebuilder:rbac:groups=cloudcredential.openshift.io,resources=credentialsrequests,verbs=get;list;watch;create;update;patch;delete
+
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=awsclusters,verbs=get;list;watch;create;update;patch;delete
+
+// awsProvider deploys the Cluster API AWS provider (CAPA).
+type awsProvider struct{}
+
+var _ infrastructureProvider = &awsProvider{}
+
+func (p *awsProvider) ValidatePlatformStatus(infra *clusterInfrastructure) error {
+	if getAWSRegion(infra.Infrastructure) == "" {
+		return fmt.Errorf("infrastructure %q has no AWS region in its platform status", infra.Name)
+	}
+	if infra.Status.InfrastructureName == "" {
+		return fmt.Errorf("infrastructure %q has no infrastructure name", infra.Name)
+	}
+	if _, err := getAWSServiceEndpoints(infra); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *awsProvider) InfrastructureCluster(name, namespace string) controllerutil.Object {
+	return CAPACluster(name, namespace)
+}
+
+func (p *awsProvider) ReconcileInfrastructureCluster(infraCluster controllerutil.Object, infra *clusterInfrastructure) error {
+	awsCluster, ok := infraCluster.(*infrav1.AWSCluster)
+	if !ok {
+		return fmt.Errorf("expected AWSCluster, got %T", infraCluster)
+	}
+	endpoint, err := getControlPlaneEndpoint(infra)
+	if err != nil {
+		return err
+	}
+	return reconcileCAPACluster(awsCluster, getAWSRegion(infra.Infrastructure), endpoint, awsAdditionalTags(infra))
+}
+
+// ReconcileInfrastructureClusterStatus is left to AWSClusterReconciler.
+func (p *awsProvider) ReconcileInfrastructureClusterStatus(ctx context.Context, c client.Client, infraCluster controllerutil.Object) error {
+	return nil
+}
+
+func (p *awsProvider) InfrastructureClusterReady(infraCluster controllerutil.Object) (bool, error) {
+	awsCluster, ok := infraCluster.(*infrav1.AWSCluster)
+	if !ok {
+		return false, fmt.Errorf("expected AWSCluster, got %T", infraCluster)
+	}
+	return awsCluster.Status.Ready, nil
+}
+
+func (p *awsProvider) ManagerDeployment(namespace string) *appsv1.Deployment {
+	return ClusterAPIAWSManagerDeployment(namespace)
+}
+
+func (p *awsProvider) ReconcileManagerDeployment(deployment *appsv1.Deployment, provider operatorv1.ProviderSpec, infra *clusterInfrastructure) error {
+	serviceEndpoints, err := getAWSServiceEndpoints(infra)
+	if err != nil {
+		return err
+	}
+	return reconcileCAPIAWSProviderDeployment(deployment, provider, serviceEndpoints, infra.shortLivedCredentials())
+}
+
+func (p *awsProvider) ManagerRBAC() managerRBAC {
+	return capaManagerRBAC
+}
+
+// ReconcileCredentials requests IAM credentials for CAPA from the cloud
+// credential operator and renders them into the shared credentials file
+// mounted by the CAPA manager. With short-lived credentials the secret is
+// created out of band from the CredentialsRequest, e.g. by ccoctl, and holds
+// the IAM role to assume with the projected service account token.
+func (p *awsProvider) ReconcileCredentials(ctx context.Context, c *eventingClient, capiDeployment *operatorv1.CAPIDeployment, infra *clusterInfrastructure) error {
+	namespace := capiDeployment.Namespace
+	shortLived := infra.shortLivedCredentials()
+	credentialsRequest := capaCredentialsRequest(namespace)
+	_, err := c.createOrUpdate(ctx, credentialsRequest, func() error {
+		return reconcileCAPACredentialsRequest(credentialsRequest, namespace, shortLived)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reconcile credentials request %s: %w", credentialsRequest.GetName(), err)
+	}
+
+	target := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      capaCredentialsSecretName,
+		},
+	}
+	source := types.NamespacedName{Namespace: namespace, Name: capaCloudCredentialsSecretName}
+	region := getAWSRegion(infra.Infrastructure)
+	return renderCredentialsSecret(ctx, c, capiDeployment, source, target, func(data map[string][]byte) (map[string][]byte, error) {
+		if shortLived {
+			return renderAWSWebIdentityCredentials(source, data, region)
+		}
+		return renderAWSSharedCredentials(source, data, region)
+	})
+}
+
+func (p *awsProvider) DeleteCredentials(ctx context.Context, c client.Client, namespace string) error {
+	if err := c.Delete(ctx, capaCredentialsRequest(namespace)); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete credentials request: %w", err)
+	}
+	return deleteCredentialsSecret(ctx, c, namespace, capaCredentialsSecretName)
+}
+
+// capaCredentialsRequest returns the CredentialsRequest for the CAPA
+// manager of a namespace. CredentialsRequests live in the cloud credential
+// operator namespace, so the name carries the target namespace.
+func capaCredentialsRequest(namespace string) *unstructured.Unstructured {
+	credentialsRequest := &unstructured.Unstructured{}
+	credentialsRequest.SetAPIVersion(credentialsRequestAPIVersion)
+	credentialsRequest.SetKind("CredentialsRequest")
+	credentialsRequest.SetNamespace(cloudCredentialOperatorNamespace)
+	credentialsRequest.SetName("openshift-cluster-api-aws-" + namespace)
+	return credentialsRequest
+}
+
+func reconcileCAPACredentialsRequest(credentialsRequest *unstructured.Unstructured, namespace string, shortLived bool) error {
+	var serviceAccountNames []string
+	if shortLived {
+		serviceAccountNames = []string{capaManagerRBAC.ServiceAccountName}
+	}
+	secret := types.NamespacedName{Namespace: namespace, Name: capaCloudCredentialsSecretName}
+	return reconcileAWSCredentialsRequest(credentialsRequest, secret, capaIAMPolicy, serviceAccountNames)
+}
+
+// reconcileAWSCredentialsRequest requests credentials for policy, written to
+// secret. The service accounts are allowed to assume the role in the trust
+// policy generated from the request when short-lived credentials are used.
+func reconcileAWSCredentialsRequest(credentialsRequest *unstructured.Unstructured, secret types.NamespacedName, policy []awsIAMStatement, serviceAccountNames []string) error {
+	statementEntries := make([]interface{}, 0, len(policy))
+	for _, statement := range policy {
+		actions := make([]interface{}, 0, len(statement.actions))
+		for _, action := range statement.actions {
+			actions = append(actions, action)
+		}
+		statementEntries = append(statementEntries, map[string]interface{}{
+			"effect":   "Allow",
+			"action":   actions,
+			"resource": statement.resource,
+		})
+	}
+
+	spec := map[string]interface{}{
+		"secretRef": map[string]interface{}{
+			"name":      secret.Name,
+			"namespace": secret.Namespace,
+		},
+		"providerSpec": map[string]interface{}{
+			"apiVersion":       credentialsRequestAPIVersion,
+			"kind":             "AWSProviderSpec",
+			"statementEntries": statementEntries,
+		},
+	}
+	if len(serviceAccountNames) > 0 {
+		names := make([]interface{}, 0, len(serviceAccountNames))
+		for _, name := range serviceAccountNames {
+			names = append(names, name)
+		}
+		spec["serviceAccountNames"] = names
+	}
+
+	return unstructured.SetNestedMap(credentialsRequest.Object, spec, "spec")
+}
+
+// renderAWSSharedCredentials turns the keys minted by the cloud credential
+// operator into an AWS shared credentials file.
+func renderAWSSharedCredentials(source types.NamespacedName, data map[string][]byte, region string) (map[string][]byte, error) {
+	accessKeyID, ok := data["aws_access_key_id"]
+	if !ok {
+		return nil, fmt.Errorf("%w: credentials secret %s has no aws_access_key_id", errCredentialsNotReady, source)
+	}
+	secretAccessKey, ok := data["aws_secret_access_key"]
+	if !ok {
+		return nil, fmt.Errorf("%w: credentials secret %s has no aws_secret_access_key", errCredentialsNotReady, source)
+	}
+
+	credentials := fmt.Sprintf("[default]\naws_access_key_id = %s\naws_secret_access_key = %s\nregion = %s\n", accessKeyID, secretAccessKey, region)
+	return map[string][]byte{"credentials": []byte(credentials)}, nil
+}
+
+// renderAWSWebIdentityCredentials turns the IAM role of a short-lived
+// credentials secret into an AWS shared credentials file that assumes the
+// role with the projected service account token.
+func renderAWSWebIdentityCredentials(source types.NamespacedName, data map[string][]byte, region string) (map[string][]byte, error) {
+	roleARN := awsRoleARN(data)
+	if roleARN == "" {
+		return nil, fmt.Errorf("%w: credentials secret %s has no role_arn", errCredentialsNotReady, source)
+	}
+
+	credentials := fmt.Sprintf("[default]\nrole_arn = %s\nweb_identity_token_file = %s/token\nregion = %s\n", roleARN, capaTokenMountPath, region)
+	return map[string][]byte{"credentials": []byte(credentials)}, nil
+}
+
+// awsIAMStatement allows actions on a resource.
+type awsIAMStatement struct {
+	actions  []string
+	resource string
+}
+
+// awsRoleARN returns the IAM role of a short-lived credentials secret, from a
+// role_arn key or from the credentials file generated by ccoctl.
+func awsRoleARN(data map[string][]byte) string {
+	if roleARN := strings.TrimSpace(string(data["role_arn"])); roleARN != "" {
+		return roleARN
+	}
+	for _, line := range strings.Split(string(data["credentials"]), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "role_arn" {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
+
+// capaIAMPolicy is the IAM policy of the CAPA controllers, restricted to what
+// is needed to manage Machines in an existing VPC.
+var capaIAMPolicy = []awsIAMStatement{
+	{
+		actions: []string{
+			"ec2:AllocateAddress",
+			"ec2:AssociateRouteTable",
+			"ec2:AttachNetworkInterface",
+			"ec2:CreateSecurityGroup",
+			"ec2:CreateTags",
+			"ec2:DeleteSecurityGroup",
+			"ec2:DeleteTags",
+			"ec2:Describe*",
+			"ec2:DetachNetworkInterface",
+			"ec2:ModifyInstanceAttribute",
+			"ec2:ModifyNetworkInterfaceAttribute",
+			"ec2:RunInstances",
+			"ec2:TerminateInstances",
+			"elasticloadbalancing:AddTags",
+			"elasticloadbalancing:Describe*",
+			"elasticloadbalancing:DeregisterInstancesFromLoadBalancer",
+			"elasticloadbalancing:DeregisterTargets",
+			"elasticloadbalancing:RegisterInstancesWithLoadBalancer",
+			"elasticloadbalancing:RegisterTargets",
+			"elasticloadbalancing:RemoveTags",
+			"iam:CreateServiceLinkedRole",
+			"iam:PassRole",
+			"secretsmanager:CreateSecret",
+			"secretsmanager:DeleteSecret",
+			"secretsmanager:TagResource",
+			"ssm:GetParameter",
+			"tag:GetResources",
+		},
+		resource: "*",
+	},
+}
+
+func getAWSRegion(infra *configv1.Infrastructure) string {
+	if infra.Status.PlatformStatus == nil || infra.Status.PlatformStatus.AWS == nil {
+		return ""
+	}
+
+	return infra.Status.PlatformStatus.AWS.Region
+}
+
+func CAPACluster(name, namespace string) *infrav1.AWSCluster {
+	return &infrav1.AWSCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: map[string]string{managedByAnnotation: managedByValue},
+		},
+	}
+}
+
+// awsAdditionalTags returns the tags the installer puts on every resource:
+// the user-defined resource tags and the cluster ownership tag.
+func awsAdditionalTags(infra *clusterInfrastructure) infrav1.Tags {
+	tags := infrav1.Tags{}
+	for key, value := range infra.AWSResourceTags {
+		tags[key] = value
+	}
+	tags[infrav1.NameKubernetesAWSCloudProviderPrefix+infra.Status.InfrastructureName] = string(infrav1.ResourceLifecycleOwned)
+	return tags
+}
+
+func reconcileCAPACluster(awsCluster *infrav1.AWSCluster, region string, endpoint clusterv1.APIEndpoint, additionalTags infrav1.Tags) error {
+	if awsCluster.Annotations == nil {
+		awsCluster.Annotations = map[string]string{}
+	}
+	awsCluster.Annotations[managedByAnnotation] = managedByValue
+	// The network is filled in by AWSClusterReconciler, so only the fields
+	// owned here are set.
+	awsCluster.Spec.Region = region
+	awsCluster.Spec.ControlPlaneEndpoint = endpoint
+	awsCluster.Spec.AdditionalTags = additionalTags
+
+	return nil
+}
+
+func ClusterAPIAWSManagerDeployment(namespace string) *appsv1.Deployment {
+	return providerManagerDeployment(capaManager, namespace)
+}
+
+// capaManager is the CAPA manager Deployment. CAPA reads its credentials
+// from a shared credentials file.
+var capaManager = providerManager{
+	Name:               "capa-controller-manager",
+	ProviderName:       operatorv1.AWSProviderName,
+	ServiceAccountName: capaManagerRBAC.ServiceAccountName,
+	Env: []corev1.EnvVar{
+		{
+			Name:  "AWS_SHARED_CREDENTIALS_FILE",
+			Value: "/home/.aws/credentials",
+		},
+	},
+	Volumes: []corev1.Volume{
+		{
+			Name: "credentials",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: capaCredentialsSecretName,
+				},
+			},
+		},
+	},
+	VolumeMounts: []corev1.VolumeMount{
+		{
+			Name:      "credentials",
+			MountPath: "/home/.aws",
+		},
+	},
+}
+
+func reconcileCAPIAWSProviderDeployment(deployment *appsv1.Deployment, provider operatorv1.ProviderSpec, serviceEndpoints []awsServiceEndpoint, shortLived bool) error {
+	manager := capaManager
+	if flag := awsServiceEndpointsFlag(serviceEndpoints); flag != "" {
+		manager.Args = []string{"--service-endpoints=" + flag}
+	}
+	if shortLived {
+		// The SDK only reads role_arn and web_identity_token_file from the
+		// shared file when it loads it as a config file.
+		manager.Env = append(append([]corev1.EnvVar{}, manager.Env...), corev1.EnvVar{
+			Name:  "AWS_SDK_LOAD_CONFIG",
+			Value: "1",
+		})
+		manager.Volumes = append(append([]corev1.Volume{}, manager.Volumes...), capaTokenVolume())
+		manager.VolumeMounts = append(append([]corev1.VolumeMount{}, manager.VolumeMounts...), corev1.VolumeMount{
+			Name:      "bound-sa-token",
+			MountPath: capaTokenMountPath,
+			ReadOnly:  true,
+		})
+	}
+	return reconcileProviderManagerDeployment(deployment, manager, provider)
+}
+
+// capaTokenVolume projects a service account token the CAPA manager
+// exchanges for temporary credentials of its IAM role.
+func capaTokenVolume() corev1.Volume {
+	expirationSeconds := int64(3600)
+	return corev1.Volume{
+		Name: "bound-sa-token",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Audience:          capaTokenAudience,
+							ExpirationSeconds: &expirationSeconds,
+							Path:              "token",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// awsServiceEndpoint is a custom endpoint for an AWS service.
+type awsServiceEndpoint struct {
+	ServiceID     string
+	URL           string
+	SigningRegion string
+}
+
+// getAWSServiceEndpoints validates the custom service endpoints of the
+// cluster. Requests are signed for the region the service lives in within
+// the cluster's partition, which differs from the cluster region for global
+// services such as IAM.
+func getAWSServiceEndpoints(infra *clusterInfrastructure) ([]awsServiceEndpoint, error) {
+	if len(infra.AWSServiceEndpoints) == 0 {
+		return nil, nil
+	}
+
+	region := getAWSRegion(infra.Infrastructure)
+	partition, knownPartition := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
+
+	serviceEndpoints := make([]awsServiceEndpoint, 0, len(infra.AWSServiceEndpoints))
+	for service, endpointURL := range infra.AWSServiceEndpoints {
+		if !isAWSService(service) {
+			return nil, fmt.Errorf("%w: unknown AWS service %q in service endpoints", errPlatformConfigInvalid, service)
+		}
+
+		u, err := url.Parse(endpointURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("%w: endpoint %q of service %s must be an https URL", errPlatformConfigInvalid, endpointURL, service)
+		}
+
+		signingRegion := region
+		if knownPartition {
+			if resolved, err := partition.EndpointFor(service, region); err == nil && resolved.SigningRegion != "" {
+				signingRegion = resolved.SigningRegion
+			}
+		}
+
+		serviceEndpoints = append(serviceEndpoints, awsServiceEndpoint{
+			ServiceID:     service,
+			URL:           endpointURL,
+			SigningRegion: signingRegion,
+		})
+	}
+
+	sort.Slice(serviceEndpoints, func(i, j int) bool {
+		return serviceEndpoints[i].ServiceID < serviceEndpoints[j].ServiceID
+	})
+
+	return serviceEndpoints, nil
+}
+
+// isAWSService reports whether the SDK knows the service in any partition.
+func isAWSService(service string) bool {
+	for _, partition := range endpoints.DefaultPartitions() {
+		if _, ok := partition.Services()[service]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// awsServiceEndpointsFlag renders endpoints in the format of the CAPA
+// --service-endpoints flag:
+// ${SigningRegion1}:${ServiceID1}=${URL},${ServiceID2}=${URL};${SigningRegion2}...
+func awsServiceEndpointsFlag(serviceEndpoints []awsServiceEndpoint) string {
+	byRegion := map[string][]string{}
+	for _, e := range serviceEndpoints {
+		byRegion[e.SigningRegion] = append(byRegion[e.SigningRegion], e.ServiceID+"="+e.URL)
+	}
+
+	regions := make([]string, 0, len(byRegion))
+	for region := range byRegion {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	groups := make([]string, 0, len(regions))
+	for _, region := range regions {
+		groups = append(groups, region+":"+strings.Join(byRegion[region], ","))
+	}
+	return strings.Join(groups, ";")
+}
+
+// capaManagerRBAC grants the permissions of the CAPA v0.6 manager.
+var capaManagerRBAC = managerRBAC{
+	ServiceAccountName: "capa-controller-manager",
+	RoleName:           "capa-manager",
+	ClusterRoleName:    "cluster-api-aws",
+	Rules: []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     allVerbs,
+		},
+		{
+			APIGroups: []string{"cluster.x-k8s.io"},
+			Resources: []string{"clusters", "clusters/status", "machines", "machines/status"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"exp.cluster.x-k8s.io"},
+			Resources: []string{"machinepools", "machinepools/status"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"controlplane.cluster.x-k8s.io"},
+			Resources: []string{"awsmanagedcontrolplanes", "awsmanagedcontrolplanes/status"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{
+				"awsclusters",
+				"awsfargateprofiles",
+				"awsmachinepools",
+				"awsmachines",
+				"awsmanagedclusters",
+				"awsmanagedmachinepools",
+			},
+			Verbs: allVerbs,
+		},
+		{
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{
+				"awsclusters/status",
+				"awsfargateprofiles/status",
+				"awsmachinepools/status",
+				"awsmachines/status",
+				"awsmanagedclusters/status",
+				"awsmanagedmachinepools/status",
+			},
+			Verbs: statusVerbs,
+		},
+	},
+	ClusterRules: []rbacv1.PolicyRule{
+		{
+			// AWS identities are cluster scoped.
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{"awsclustercontrolleridentities"},
+			Verbs:     []string{"get", "list", "watch", "create"},
+		},
+		{
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{"awsclusterroleidentities", "awsclusterstaticidentities"},
+			Verbs:     readVerbs,
+		},
+	},
+}
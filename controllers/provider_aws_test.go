@@ -0,0 +1,153 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/api/v1alpha3"
+)
+
+func TestAWSAdditionalTags(t *testing.T) {
+	g := NewWithT(t)
+
+	infra := &clusterInfrastructure{
+		Infrastructure: &configv1.Infrastructure{
+			Status: configv1.InfrastructureStatus{InfrastructureName: "test-abcde"},
+		},
+		AWSResourceTags: map[string]string{
+			"cost-center": "42",
+			// The ownership tag cannot be overridden by a user tag.
+			"kubernetes.io/cluster/test-abcde": "shared",
+		},
+	}
+
+	g.Expect(awsAdditionalTags(infra)).To(Equal(infrav1.Tags{
+		"cost-center":                      "42",
+		"kubernetes.io/cluster/test-abcde": "owned",
+	}))
+}
+
+func testAWSInfrastructure(region string, serviceEndpoints map[string]string) *clusterInfrastructure {
+	return &clusterInfrastructure{
+		Infrastructure: &configv1.Infrastructure{
+			Status: configv1.InfrastructureStatus{
+				InfrastructureName: "test-abcde",
+				PlatformStatus: &configv1.PlatformStatus{
+					Type: configv1.AWSPlatformType,
+					AWS:  &configv1.AWSPlatformStatus{Region: region},
+				},
+			},
+		},
+		AWSServiceEndpoints: serviceEndpoints,
+	}
+}
+
+func TestGetAWSServiceEndpoints(t *testing.T) {
+	g := NewWithT(t)
+
+	serviceEndpoints, err := getAWSServiceEndpoints(testAWSInfrastructure("us-west-2", map[string]string{
+		"ec2": "https://ec2.example.com",
+		"iam": "https://iam.example.com",
+	}))
+	g.Expect(err).NotTo(HaveOccurred())
+	// IAM is global and signed in the partition's home region.
+	g.Expect(awsServiceEndpointsFlag(serviceEndpoints)).To(Equal("us-east-1:iam=https://iam.example.com;us-west-2:ec2=https://ec2.example.com"))
+
+	serviceEndpoints, err = getAWSServiceEndpoints(testAWSInfrastructure("us-gov-west-1", map[string]string{
+		"ec2": "https://ec2.us-gov-west-1.example.com",
+		"iam": "https://iam.us-gov.example.com",
+	}))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(awsServiceEndpointsFlag(serviceEndpoints)).To(Equal("us-gov-west-1:ec2=https://ec2.us-gov-west-1.example.com,iam=https://iam.us-gov.example.com"))
+
+	_, err = getAWSServiceEndpoints(testAWSInfrastructure("us-west-2", map[string]string{"ec2": "http://ec2.example.com"}))
+	g.Expect(errors.Is(err, errPlatformConfigInvalid)).To(BeTrue())
+
+	_, err = getAWSServiceEndpoints(testAWSInfrastructure("us-west-2", map[string]string{"not-a-service": "https://example.com"}))
+	g.Expect(errors.Is(err, errPlatformConfigInvalid)).To(BeTrue())
+
+	g.Expect(new(awsProvider).ValidatePlatformStatus(testAWSInfrastructure("us-west-2", nil))).To(Succeed())
+}
+
+func TestRenderAWSSharedCredentials(t *testing.T) {
+	g := NewWithT(t)
+
+	source := types.NamespacedName{Namespace: "openshift-cluster-api", Name: capaCloudCredentialsSecretName}
+
+	data, err := renderAWSSharedCredentials(source, map[string][]byte{
+		"aws_access_key_id":     []byte("AKIAEXAMPLE"),
+		"aws_secret_access_key": []byte("secret"),
+	}, "us-east-1")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(data["credentials"])).To(Equal("[default]\naws_access_key_id = AKIAEXAMPLE\naws_secret_access_key = secret\nregion = us-east-1\n"))
+
+	// The cloud credential operator has not minted the keys yet.
+	_, err = renderAWSSharedCredentials(source, map[string][]byte{}, "us-east-1")
+	g.Expect(errors.Is(err, errCredentialsNotReady)).To(BeTrue())
+}
+
+func TestRenderAWSWebIdentityCredentials(t *testing.T) {
+	g := NewWithT(t)
+
+	source := types.NamespacedName{Namespace: "openshift-cluster-api", Name: capaCloudCredentialsSecretName}
+	expected := "[default]\nrole_arn = arn:aws:iam::123456789012:role/capa\nweb_identity_token_file = /var/run/secrets/openshift/serviceaccount/token\nregion = us-east-1\n"
+
+	data, err := renderAWSWebIdentityCredentials(source, map[string][]byte{
+		"role_arn": []byte("arn:aws:iam::123456789012:role/capa"),
+	}, "us-east-1")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(data["credentials"])).To(Equal(expected))
+
+	// Secrets generated by ccoctl carry the role in a credentials file.
+	data, err = renderAWSWebIdentityCredentials(source, map[string][]byte{
+		"credentials": []byte("[default]\nrole_arn = arn:aws:iam::123456789012:role/capa\nweb_identity_token_file = /var/run/secrets/openshift/serviceaccount/token\n"),
+	}, "us-east-1")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(data["credentials"])).To(Equal(expected))
+
+	_, err = renderAWSWebIdentityCredentials(source, map[string][]byte{}, "us-east-1")
+	g.Expect(errors.Is(err, errCredentialsNotReady)).To(BeTrue())
+}
+
+func TestReconcileCAPIAWSProviderDeploymentShortLived(t *testing.T) {
+	g := NewWithT(t)
+
+	provider := operatorv1.ProviderSpec{Name: operatorv1.AWSProviderName}
+
+	deployment := ClusterAPIAWSManagerDeployment("openshift-cluster-api")
+	g.Expect(reconcileCAPIAWSProviderDeployment(deployment, provider, nil, false)).To(Succeed())
+	container := deployment.Spec.Template.Spec.Containers[0]
+	g.Expect(container.Env).To(HaveLen(2))
+	g.Expect(container.Env[1]).To(Equal(capaManager.Env[0]))
+
+	deployment = ClusterAPIAWSManagerDeployment("openshift-cluster-api")
+	g.Expect(reconcileCAPIAWSProviderDeployment(deployment, provider, nil, true)).To(Succeed())
+	container = deployment.Spec.Template.Spec.Containers[0]
+	g.Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "AWS_SHARED_CREDENTIALS_FILE", Value: "/home/.aws/credentials"}))
+	g.Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "AWS_SDK_LOAD_CONFIG", Value: "1"}))
+	g.Expect(container.VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "bound-sa-token", MountPath: capaTokenMountPath, ReadOnly: true}))
+
+	// The shared manager template is not modified.
+	g.Expect(capaManager.Env).To(HaveLen(1))
+}
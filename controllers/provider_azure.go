@@ -0,0 +1,312 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// capzCredentialsSecretName is the secret the CAPZ manager reads its
+	// service principal from.
+	capzCredentialsSecretName = "capz-manager-bootstrap-credentials"
+
+	defaultAzureCloudName = "AzurePublicCloud"
+)
+
+// installConfigMap holds the install config the installer used, under the
+// install-config key.
+var installConfigMap = types.NamespacedName{Namespace: "kube-system", Name: "cluster-config-v1"}
+
+// azureCredentialsSecret holds the service principal the installer created
+// for the cluster.
+var azureCredentialsSecret = types.NamespacedName{Namespace: cloudCredentialsNamespace, Name: "azure-credentials"}
+
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=azureclusters,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=azureclusters/status,verbs=get;update;patch
+
+// azureProvider deploys the Cluster API Azure provider (CAPZ).
+type azureProvider struct{}
+
+var _ infrastructureProvider = &azureProvider{}
+
+// azureCloudConfig is the part of the Azure cloud provider config that
+// describes where the cluster lives.
+type azureCloudConfig struct {
+	SubscriptionID    string `json:"subscriptionId"`
+	Location          string `json:"location"`
+	VnetName          string `json:"vnetName"`
+	VnetResourceGroup string `json:"vnetResourceGroup"`
+	SubnetName        string `json:"subnetName"`
+}
+
+func getAzureCloudConfig(infra *clusterInfrastructure) (*azureCloudConfig, error) {
+	if infra.CloudConfig == "" {
+		return nil, fmt.Errorf("infrastructure %q references no cloud provider config", infra.Name)
+	}
+
+	cloudConfig := &azureCloudConfig{}
+	if err := json.Unmarshal([]byte(infra.CloudConfig), cloudConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse Azure cloud provider config: %w", err)
+	}
+
+	return cloudConfig, nil
+}
+
+// getAzureControlPlaneSubnet reads the control plane subnet from the install
+// config. Clusters whose install config is gone are treated as installed into
+// a virtual network created by the installer.
+func getAzureControlPlaneSubnet(ctx context.Context, c client.Client) (string, error) {
+	installConfig := &corev1.ConfigMap{}
+	if err := c.Get(ctx, installConfigMap, installConfig); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get install config: %w", err)
+	}
+	return parseAzureControlPlaneSubnet(installConfig.Data["install-config"])
+}
+
+// parseAzureControlPlaneSubnet reads platform.azure.controlPlaneSubnet from a
+// YAML install config.
+func parseAzureControlPlaneSubnet(installConfig string) (string, error) {
+	config := struct {
+		Platform struct {
+			Azure struct {
+				ControlPlaneSubnet string `json:"controlPlaneSubnet"`
+			} `json:"azure"`
+		} `json:"platform"`
+	}{}
+	if err := yaml.Unmarshal([]byte(installConfig), &config); err != nil {
+		return "", fmt.Errorf("failed to parse install config: %w", err)
+	}
+	return config.Platform.Azure.ControlPlaneSubnet, nil
+}
+
+// azureControlPlaneSubnet is the subnet of the control plane Machines, the
+// one named in the install config or the one the installer creates.
+func azureControlPlaneSubnet(infra *clusterInfrastructure) string {
+	if infra.AzureControlPlaneSubnet != "" {
+		return infra.AzureControlPlaneSubnet
+	}
+	return infra.Status.InfrastructureName + "-master-subnet"
+}
+
+func (p *azureProvider) ValidatePlatformStatus(infra *clusterInfrastructure) error {
+	if infra.Status.PlatformStatus == nil || infra.Status.PlatformStatus.Azure == nil ||
+		infra.Status.PlatformStatus.Azure.ResourceGroupName == "" {
+		return fmt.Errorf("infrastructure %q has no Azure resource group in its platform status", infra.Name)
+	}
+
+	cloudConfig, err := getAzureCloudConfig(infra)
+	if err != nil {
+		return err
+	}
+	if cloudConfig.Location == "" || cloudConfig.SubscriptionID == "" || cloudConfig.VnetName == "" {
+		return fmt.Errorf("cloud provider config is missing the Azure location, subscriptionId or vnetName")
+	}
+
+	return nil
+}
+
+func (p *azureProvider) InfrastructureCluster(name, namespace string) controllerutil.Object {
+	return CAPZCluster(name, namespace)
+}
+
+func (p *azureProvider) ReconcileInfrastructureCluster(infraCluster controllerutil.Object, infra *clusterInfrastructure) error {
+	cloudConfig, err := getAzureCloudConfig(infra)
+	if err != nil {
+		return err
+	}
+	return setInfrastructureClusterSpec(infraCluster, azureClusterSpec(infra, cloudConfig))
+}
+
+// ReconcileInfrastructureClusterStatus marks the AzureCluster ready, the
+// network it points at was created by the installer.
+func (p *azureProvider) ReconcileInfrastructureClusterStatus(ctx context.Context, c client.Client, infraCluster controllerutil.Object) error {
+	return markInfrastructureClusterReady(ctx, c, infraCluster)
+}
+
+func (p *azureProvider) InfrastructureClusterReady(infraCluster controllerutil.Object) (bool, error) {
+	return unstructuredInfrastructureClusterReady(infraCluster)
+}
+
+func (p *azureProvider) ManagerDeployment(namespace string) *appsv1.Deployment {
+	return providerManagerDeployment(capzManager, namespace)
+}
+
+func (p *azureProvider) ReconcileManagerDeployment(deployment *appsv1.Deployment, provider operatorv1.ProviderSpec, infra *clusterInfrastructure) error {
+	manager := capzManager
+	manager.Env = append([]corev1.EnvVar{{Name: "AZURE_ENVIRONMENT", Value: getAzureCloudName(infra)}}, manager.Env...)
+	return reconcileProviderManagerDeployment(deployment, manager, provider)
+}
+
+func (p *azureProvider) ManagerRBAC() managerRBAC {
+	return capzManagerRBAC
+}
+
+// ReconcileCredentials copies the cluster's service principal into the
+// secret read by the CAPZ manager.
+func (p *azureProvider) ReconcileCredentials(ctx context.Context, c *eventingClient, capiDeployment *operatorv1.CAPIDeployment, infra *clusterInfrastructure) error {
+	namespace := capiDeployment.Namespace
+	target := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      capzCredentialsSecretName,
+		},
+	}
+	return syncCredentialsSecret(ctx, c, capiDeployment, azureCredentialsSecret, target, map[string]string{
+		"azure_subscription_id": "subscription-id",
+		"azure_tenant_id":       "tenant-id",
+		"azure_client_id":       "client-id",
+		"azure_client_secret":   "client-secret",
+	})
+}
+
+func getAzureCloudName(infra *clusterInfrastructure) string {
+	if infra.AzureCloudName == "" {
+		return defaultAzureCloudName
+	}
+	return infra.AzureCloudName
+}
+
+func (p *azureProvider) DeleteCredentials(ctx context.Context, c client.Client, namespace string) error {
+	return deleteCredentialsSecret(ctx, c, namespace, capzCredentialsSecretName)
+}
+
+func CAPZCluster(name, namespace string) controllerutil.Object {
+	return newInfrastructureCluster("AzureCluster", name, namespace)
+}
+
+// azureClusterSpec points the AzureCluster at the resource groups, virtual
+// network and subnets the cluster was installed into.
+func azureClusterSpec(infra *clusterInfrastructure, cloudConfig *azureCloudConfig) map[string]interface{} {
+	azure := infra.Status.PlatformStatus.Azure
+
+	networkResourceGroup := azure.NetworkResourceGroupName
+	if networkResourceGroup == "" {
+		networkResourceGroup = cloudConfig.VnetResourceGroup
+	}
+	if networkResourceGroup == "" {
+		networkResourceGroup = azure.ResourceGroupName
+	}
+
+	return map[string]interface{}{
+		"location":       cloudConfig.Location,
+		"subscriptionID": cloudConfig.SubscriptionID,
+		"resourceGroup":  azure.ResourceGroupName,
+		"networkSpec": map[string]interface{}{
+			"vnet": map[string]interface{}{
+				"name":          cloudConfig.VnetName,
+				"resourceGroup": networkResourceGroup,
+			},
+			"subnets": []interface{}{
+				map[string]interface{}{
+					"role": "control-plane",
+					"name": azureControlPlaneSubnet(infra),
+				},
+				map[string]interface{}{
+					"role": "node",
+					"name": cloudConfig.SubnetName,
+				},
+			},
+		},
+	}
+}
+
+// capzManager is the CAPZ manager Deployment. CAPZ reads its service
+// principal from the environment.
+var capzManager = providerManager{
+	Name:               "capz-controller-manager",
+	ProviderName:       operatorv1.AzureProviderName,
+	ServiceAccountName: capzManagerRBAC.ServiceAccountName,
+	Env: []corev1.EnvVar{
+		secretEnvVar("AZURE_SUBSCRIPTION_ID", capzCredentialsSecretName, "subscription-id"),
+		secretEnvVar("AZURE_TENANT_ID", capzCredentialsSecretName, "tenant-id"),
+		secretEnvVar("AZURE_CLIENT_ID", capzCredentialsSecretName, "client-id"),
+		secretEnvVar("AZURE_CLIENT_SECRET", capzCredentialsSecretName, "client-secret"),
+	},
+}
+
+// capzManagerRBAC grants the permissions of the CAPZ v0.4 manager. CAPZ
+// needs nothing cluster scoped.
+var capzManagerRBAC = managerRBAC{
+	ServiceAccountName: "capz-controller-manager",
+	RoleName:           "capz-manager",
+	Rules: []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     allVerbs,
+		},
+		{
+			APIGroups: []string{"cluster.x-k8s.io"},
+			Resources: []string{"clusters", "clusters/status", "machines", "machines/status"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"exp.cluster.x-k8s.io"},
+			Resources: []string{"machinepools", "machinepools/status"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{
+				"azureclusteridentities",
+				"azureclusters",
+				"azuremachinepools",
+				"azuremachines",
+				"azuremachinetemplates",
+				"azuremanagedclusters",
+				"azuremanagedcontrolplanes",
+				"azuremanagedmachinepools",
+			},
+			Verbs: allVerbs,
+		},
+		{
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{
+				"azureclusteridentities/status",
+				"azureclusters/status",
+				"azuremachinepools/status",
+				"azuremachines/status",
+				"azuremanagedclusters/status",
+				"azuremanagedcontrolplanes/status",
+				"azuremanagedmachinepools/status",
+			},
+			Verbs: statusVerbs,
+		},
+	},
+}
@@ -0,0 +1,294 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func testAzureInfrastructure(azure *configv1.AzurePlatformStatus, cloudConfig string) *clusterInfrastructure {
+	return &clusterInfrastructure{
+		Infrastructure: &configv1.Infrastructure{
+			Status: configv1.InfrastructureStatus{
+				InfrastructureName: "test-abcde",
+				PlatformStatus: &configv1.PlatformStatus{
+					Type:  configv1.AzurePlatformType,
+					Azure: azure,
+				},
+			},
+		},
+		CloudConfig: cloudConfig,
+	}
+}
+
+func TestGetAzureCloudConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		cloudConfig string
+		expected    *azureCloudConfig
+		expectError bool
+	}{
+		{
+			name: "installer provisioned network",
+			cloudConfig: `{
+				"cloud": "AzurePublicCloud",
+				"subscriptionId": "00000000-0000-0000-0000-000000000000",
+				"resourceGroup": "test-abcde-rg",
+				"location": "centralus",
+				"vnetName": "test-abcde-vnet",
+				"subnetName": "test-abcde-worker-subnet"
+			}`,
+			expected: &azureCloudConfig{
+				SubscriptionID: "00000000-0000-0000-0000-000000000000",
+				Location:       "centralus",
+				VnetName:       "test-abcde-vnet",
+				SubnetName:     "test-abcde-worker-subnet",
+			},
+		},
+		{
+			name: "existing network",
+			cloudConfig: `{
+				"subscriptionId": "00000000-0000-0000-0000-000000000000",
+				"location": "centralus",
+				"vnetName": "byo-vnet",
+				"vnetResourceGroup": "byo-network-rg",
+				"subnetName": "byo-compute-subnet"
+			}`,
+			expected: &azureCloudConfig{
+				SubscriptionID:    "00000000-0000-0000-0000-000000000000",
+				Location:          "centralus",
+				VnetName:          "byo-vnet",
+				VnetResourceGroup: "byo-network-rg",
+				SubnetName:        "byo-compute-subnet",
+			},
+		},
+		{
+			name:        "no cloud config",
+			expectError: true,
+		},
+		{
+			name:        "invalid JSON",
+			cloudConfig: "[Global]\nvnetName = test\n",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cloudConfig, err := getAzureCloudConfig(testAzureInfrastructure(nil, tt.cloudConfig))
+			if tt.expectError {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(cloudConfig).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestParseAzureControlPlaneSubnet(t *testing.T) {
+	tests := []struct {
+		name          string
+		installConfig string
+		expected      string
+		expectError   bool
+	}{
+		{
+			name: "existing network",
+			installConfig: `apiVersion: v1
+baseDomain: example.com
+metadata:
+  name: test
+platform:
+  azure:
+    region: centralus
+    networkResourceGroupName: byo-network-rg
+    virtualNetwork: byo-vnet
+    controlPlaneSubnet: byo-control-plane-subnet
+    computeSubnet: byo-compute-subnet
+`,
+			expected: "byo-control-plane-subnet",
+		},
+		{
+			name: "installer provisioned network",
+			installConfig: `apiVersion: v1
+platform:
+  azure:
+    region: centralus
+`,
+		},
+		{
+			name: "other platform",
+			installConfig: `apiVersion: v1
+platform:
+  aws:
+    region: us-east-1
+`,
+		},
+		{
+			name:          "invalid YAML",
+			installConfig: "platform: [",
+			expectError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			subnet, err := parseAzureControlPlaneSubnet(tt.installConfig)
+			if tt.expectError {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(subnet).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestGetAzureControlPlaneSubnet(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	subnet, err := getAzureControlPlaneSubnet(ctx, newFakeClient())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(subnet).To(BeEmpty())
+
+	c := newFakeClient(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: installConfigMap.Namespace, Name: installConfigMap.Name},
+		Data: map[string]string{
+			"install-config": "platform:\n  azure:\n    controlPlaneSubnet: byo-control-plane-subnet\n",
+		},
+	})
+	subnet, err = getAzureControlPlaneSubnet(ctx, c)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(subnet).To(Equal("byo-control-plane-subnet"))
+}
+
+func TestAzureClusterSpec(t *testing.T) {
+	tests := []struct {
+		name                    string
+		azure                   *configv1.AzurePlatformStatus
+		cloudConfig             *azureCloudConfig
+		controlPlaneSubnet      string
+		expectedNetworkRG       string
+		expectedControlPlaneNet string
+	}{
+		{
+			name:                    "installer provisioned network",
+			azure:                   &configv1.AzurePlatformStatus{ResourceGroupName: "test-abcde-rg"},
+			cloudConfig:             &azureCloudConfig{VnetName: "test-abcde-vnet", SubnetName: "test-abcde-worker-subnet"},
+			expectedNetworkRG:       "test-abcde-rg",
+			expectedControlPlaneNet: "test-abcde-master-subnet",
+		},
+		{
+			name:                    "network resource group from the cloud config",
+			azure:                   &configv1.AzurePlatformStatus{ResourceGroupName: "test-abcde-rg"},
+			cloudConfig:             &azureCloudConfig{VnetName: "byo-vnet", VnetResourceGroup: "byo-network-rg", SubnetName: "byo-compute-subnet"},
+			controlPlaneSubnet:      "byo-control-plane-subnet",
+			expectedNetworkRG:       "byo-network-rg",
+			expectedControlPlaneNet: "byo-control-plane-subnet",
+		},
+		{
+			name:                    "network resource group from the platform status first",
+			azure:                   &configv1.AzurePlatformStatus{ResourceGroupName: "test-abcde-rg", NetworkResourceGroupName: "status-network-rg"},
+			cloudConfig:             &azureCloudConfig{VnetName: "byo-vnet", VnetResourceGroup: "byo-network-rg", SubnetName: "byo-compute-subnet"},
+			controlPlaneSubnet:      "byo-control-plane-subnet",
+			expectedNetworkRG:       "status-network-rg",
+			expectedControlPlaneNet: "byo-control-plane-subnet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tt.cloudConfig.SubscriptionID = "00000000-0000-0000-0000-000000000000"
+			tt.cloudConfig.Location = "centralus"
+			infra := testAzureInfrastructure(tt.azure, "")
+			infra.AzureControlPlaneSubnet = tt.controlPlaneSubnet
+
+			g.Expect(azureClusterSpec(infra, tt.cloudConfig)).To(Equal(map[string]interface{}{
+				"location":       "centralus",
+				"subscriptionID": "00000000-0000-0000-0000-000000000000",
+				"resourceGroup":  "test-abcde-rg",
+				"networkSpec": map[string]interface{}{
+					"vnet": map[string]interface{}{
+						"name":          tt.cloudConfig.VnetName,
+						"resourceGroup": tt.expectedNetworkRG,
+					},
+					"subnets": []interface{}{
+						map[string]interface{}{
+							"role": "control-plane",
+							"name": tt.expectedControlPlaneNet,
+						},
+						map[string]interface{}{
+							"role": "node",
+							"name": tt.cloudConfig.SubnetName,
+						},
+					},
+				},
+			}))
+		})
+	}
+}
+
+func TestReconcileAzureCredentials(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	capiDeployment := &operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"}}
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: azureCredentialsSecret.Namespace, Name: azureCredentialsSecret.Name},
+		Data: map[string][]byte{
+			"azure_subscription_id": []byte("subscription"),
+			"azure_tenant_id":       []byte("tenant"),
+			"azure_client_id":       []byte("client"),
+			"azure_client_secret":   []byte("secret"),
+			"azure_region":          []byte("centralus"),
+		},
+	}
+	c := &eventingClient{Client: newFakeClient(source), recorder: record.NewFakeRecorder(10), scheme: testScheme, owner: capiDeployment}
+
+	g.Expect(new(azureProvider).ReconcileCredentials(ctx, c, capiDeployment, testAzureInfrastructure(nil, ""))).To(Succeed())
+
+	target := &corev1.Secret{}
+	g.Expect(c.Get(ctx, types.NamespacedName{Namespace: capiDeployment.Namespace, Name: capzCredentialsSecretName}, target)).To(Succeed())
+	g.Expect(target.Data).To(Equal(map[string][]byte{
+		"subscription-id": []byte("subscription"),
+		"tenant-id":       []byte("tenant"),
+		"client-id":       []byte("client"),
+		"client-secret":   []byte("secret"),
+	}))
+
+	// A service principal without its secret is rejected.
+	delete(source.Data, "azure_client_secret")
+	g.Expect(c.Update(ctx, source)).To(Succeed())
+	g.Expect(new(azureProvider).ReconcileCredentials(ctx, c, capiDeployment, testAzureInfrastructure(nil, ""))).NotTo(Succeed())
+}
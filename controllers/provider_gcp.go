@@ -0,0 +1,191 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// capgCredentialsSecretName is the secret holding the service account
+	// key mounted into the CAPG manager.
+	capgCredentialsSecretName = "capg-manager-bootstrap-credentials"
+)
+
+// gcpCredentialsSecret holds the service account key the installer created
+// for the cluster.
+var gcpCredentialsSecret = types.NamespacedName{Namespace: cloudCredentialsNamespace, Name: "gcp-credentials"}
+
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=gcpclusters,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=gcpclusters/status,verbs=get;update;patch
+
+// gcpProvider deploys the Cluster API GCP provider (CAPG).
+type gcpProvider struct{}
+
+var _ infrastructureProvider = &gcpProvider{}
+
+func (p *gcpProvider) ValidatePlatformStatus(infra *clusterInfrastructure) error {
+	if infra.Status.PlatformStatus == nil || infra.Status.PlatformStatus.GCP == nil {
+		return fmt.Errorf("infrastructure %q has no GCP platform status", infra.Name)
+	}
+	gcp := infra.Status.PlatformStatus.GCP
+	if gcp.ProjectID == "" || gcp.Region == "" {
+		return fmt.Errorf("infrastructure %q has no GCP project ID or region in its platform status", infra.Name)
+	}
+	return nil
+}
+
+func (p *gcpProvider) InfrastructureCluster(name, namespace string) controllerutil.Object {
+	return CAPGCluster(name, namespace)
+}
+
+func (p *gcpProvider) ReconcileInfrastructureCluster(infraCluster controllerutil.Object, infra *clusterInfrastructure) error {
+	return setInfrastructureClusterSpec(infraCluster, gcpClusterSpec(infra))
+}
+
+// ReconcileInfrastructureClusterStatus marks the GCPCluster ready, the
+// network it points at was created by the installer.
+func (p *gcpProvider) ReconcileInfrastructureClusterStatus(ctx context.Context, c client.Client, infraCluster controllerutil.Object) error {
+	return markInfrastructureClusterReady(ctx, c, infraCluster)
+}
+
+func (p *gcpProvider) InfrastructureClusterReady(infraCluster controllerutil.Object) (bool, error) {
+	return unstructuredInfrastructureClusterReady(infraCluster)
+}
+
+func (p *gcpProvider) ManagerDeployment(namespace string) *appsv1.Deployment {
+	return providerManagerDeployment(capgManager, namespace)
+}
+
+func (p *gcpProvider) ReconcileManagerDeployment(deployment *appsv1.Deployment, provider operatorv1.ProviderSpec, infra *clusterInfrastructure) error {
+	return reconcileProviderManagerDeployment(deployment, capgManager, provider)
+}
+
+func (p *gcpProvider) ManagerRBAC() managerRBAC {
+	return capgManagerRBAC
+}
+
+// ReconcileCredentials copies the cluster's service account key into the
+// secret mounted by the CAPG manager.
+func (p *gcpProvider) ReconcileCredentials(ctx context.Context, c *eventingClient, capiDeployment *operatorv1.CAPIDeployment, infra *clusterInfrastructure) error {
+	namespace := capiDeployment.Namespace
+	target := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      capgCredentialsSecretName,
+		},
+	}
+	return syncCredentialsSecret(ctx, c, capiDeployment, gcpCredentialsSecret, target, map[string]string{
+		"service_account.json": "credentials.json",
+	})
+}
+
+func (p *gcpProvider) DeleteCredentials(ctx context.Context, c client.Client, namespace string) error {
+	return deleteCredentialsSecret(ctx, c, namespace, capgCredentialsSecretName)
+}
+
+func CAPGCluster(name, namespace string) controllerutil.Object {
+	return newInfrastructureCluster("GCPCluster", name, namespace)
+}
+
+// gcpClusterSpec points the GCPCluster at the project, region and network
+// the installer used.
+func gcpClusterSpec(infra *clusterInfrastructure) map[string]interface{} {
+	gcp := infra.Status.PlatformStatus.GCP
+
+	return map[string]interface{}{
+		"project": gcp.ProjectID,
+		"region":  gcp.Region,
+		"network": map[string]interface{}{
+			"name": infra.Status.InfrastructureName + "-network",
+		},
+	}
+}
+
+// capgManager is the CAPG manager Deployment. CAPG reads its service account
+// key from the file named by GOOGLE_APPLICATION_CREDENTIALS.
+var capgManager = providerManager{
+	Name:               "capg-controller-manager",
+	ProviderName:       operatorv1.GCPProviderName,
+	ServiceAccountName: capgManagerRBAC.ServiceAccountName,
+	Env: []corev1.EnvVar{
+		{
+			Name:  "GOOGLE_APPLICATION_CREDENTIALS",
+			Value: "/home/.gcp/credentials.json",
+		},
+	},
+	Volumes: []corev1.Volume{
+		{
+			Name: "credentials",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: capgCredentialsSecretName,
+				},
+			},
+		},
+	},
+	VolumeMounts: []corev1.VolumeMount{
+		{
+			Name:      "credentials",
+			MountPath: "/home/.gcp",
+		},
+	},
+}
+
+// capgManagerRBAC grants the permissions of the CAPG v0.3 manager. CAPG
+// needs nothing cluster scoped.
+var capgManagerRBAC = managerRBAC{
+	ServiceAccountName: "capg-controller-manager",
+	RoleName:           "capg-manager",
+	Rules: []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"cluster.x-k8s.io"},
+			Resources: []string{"clusters", "clusters/status", "machines", "machines/status"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{"gcpclusters", "gcpmachines", "gcpmachinetemplates"},
+			Verbs:     allVerbs,
+		},
+		{
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{"gcpclusters/status", "gcpmachines/status"},
+			Verbs:     statusVerbs,
+		},
+	},
+}
@@ -0,0 +1,125 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func testGCPInfrastructure(gcp *configv1.GCPPlatformStatus) *clusterInfrastructure {
+	return &clusterInfrastructure{
+		Infrastructure: &configv1.Infrastructure{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Status: configv1.InfrastructureStatus{
+				InfrastructureName: "test-abcde",
+				PlatformStatus: &configv1.PlatformStatus{
+					Type: configv1.GCPPlatformType,
+					GCP:  gcp,
+				},
+			},
+		},
+	}
+}
+
+func TestGCPValidatePlatformStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		gcp         *configv1.GCPPlatformStatus
+		expectError bool
+	}{
+		{
+			name: "project and region",
+			gcp:  &configv1.GCPPlatformStatus{ProjectID: "test-project", Region: "us-central1"},
+		},
+		{
+			name:        "no GCP platform status",
+			expectError: true,
+		},
+		{
+			name:        "no project",
+			gcp:         &configv1.GCPPlatformStatus{Region: "us-central1"},
+			expectError: true,
+		},
+		{
+			name:        "no region",
+			gcp:         &configv1.GCPPlatformStatus{ProjectID: "test-project"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := new(gcpProvider).ValidatePlatformStatus(testGCPInfrastructure(tt.gcp))
+			if tt.expectError {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestGCPClusterSpec(t *testing.T) {
+	g := NewWithT(t)
+
+	infra := testGCPInfrastructure(&configv1.GCPPlatformStatus{ProjectID: "test-project", Region: "us-central1"})
+	g.Expect(gcpClusterSpec(infra)).To(Equal(map[string]interface{}{
+		"project": "test-project",
+		"region":  "us-central1",
+		"network": map[string]interface{}{
+			"name": "test-abcde-network",
+		},
+	}))
+}
+
+func TestReconcileGCPCredentials(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	capiDeployment := &operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"}}
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: gcpCredentialsSecret.Namespace, Name: gcpCredentialsSecret.Name},
+		Data: map[string][]byte{
+			"service_account.json": []byte(`{"type": "service_account", "project_id": "test-project"}`),
+		},
+	}
+	c := &eventingClient{Client: newFakeClient(source), recorder: record.NewFakeRecorder(10), scheme: testScheme, owner: capiDeployment}
+	infra := testGCPInfrastructure(&configv1.GCPPlatformStatus{ProjectID: "test-project", Region: "us-central1"})
+
+	g.Expect(new(gcpProvider).ReconcileCredentials(ctx, c, capiDeployment, infra)).To(Succeed())
+
+	// The key lands where GOOGLE_APPLICATION_CREDENTIALS points the manager.
+	target := &corev1.Secret{}
+	g.Expect(c.Get(ctx, types.NamespacedName{Namespace: capiDeployment.Namespace, Name: capgCredentialsSecretName}, target)).To(Succeed())
+	g.Expect(target.Data).To(Equal(map[string][]byte{"credentials.json": source.Data["service_account.json"]}))
+	g.Expect(capgManager.Env).To(ContainElement(corev1.EnvVar{Name: "GOOGLE_APPLICATION_CREDENTIALS", Value: capgManager.VolumeMounts[0].MountPath + "/credentials.json"}))
+
+	delete(source.Data, "service_account.json")
+	g.Expect(c.Update(ctx, source)).To(Succeed())
+	g.Expect(new(gcpProvider).ReconcileCredentials(ctx, c, capiDeployment, infra)).NotTo(Succeed())
+}
@@ -0,0 +1,311 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	k8sutilspointer "k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// credentialsHashAnnotation records on a manager pod template a hash of the
+// secrets the pods read, so that rotated credentials roll the pods.
+const credentialsHashAnnotation = "capi.openshift.io/credentials-hash"
+
+// providerManager describes the parts of an infrastructure provider manager
+// Deployment that differ between providers.
+type providerManager struct {
+	// Name is used for the Deployment and its control-plane label.
+	Name string
+	// ProviderName selects the release images when the CAPIDeployment does
+	// not set an image.
+	ProviderName string
+	// ServiceAccountName is the ServiceAccount the manager runs as.
+	ServiceAccountName string
+	// Args are passed to the manager before the flags derived from the
+	// CAPIDeployment, so that ExtraArgs can override them.
+	Args []string
+	// Env is added to the manager container after MY_NAMESPACE.
+	Env []corev1.EnvVar
+	// Volumes are added to the pod, typically to mount credentials.
+	Volumes []corev1.Volume
+	// VolumeMounts are added to the manager container.
+	VolumeMounts []corev1.VolumeMount
+}
+
+func providerManagerDeployment(manager providerManager, namespace string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      manager.Name,
+		},
+	}
+}
+
+// reconcileProviderManagerDeployment sets the desired state of an
+// infrastructure provider manager Deployment.
+func reconcileProviderManagerDeployment(deployment *appsv1.Deployment, manager providerManager, provider operatorv1.ProviderSpec) error {
+	image, err := providerImage(provider, manager.ProviderName)
+	if err != nil {
+		return err
+	}
+
+	setManagerDeploymentSpec(deployment, appsv1.DeploymentSpec{
+		Replicas: providerReplicas(provider),
+		Selector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				"control-plane": manager.Name,
+			},
+		},
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{
+					"control-plane": manager.Name,
+				},
+			},
+			Spec: corev1.PodSpec{
+				ServiceAccountName:            manager.ServiceAccountName,
+				TerminationGracePeriodSeconds: k8sutilspointer.Int64Ptr(10),
+				Tolerations: []corev1.Toleration{
+					{
+						Key:    "node-role.kubernetes.io/master",
+						Effect: corev1.TaintEffectNoSchedule,
+					},
+				},
+				Volumes: manager.Volumes,
+				Containers: []corev1.Container{
+					{
+						Name:            "manager",
+						Image:           image,
+						ImagePullPolicy: corev1.PullAlways,
+						VolumeMounts:    manager.VolumeMounts,
+						Env: append([]corev1.EnvVar{
+							{
+								Name: "MY_NAMESPACE",
+								ValueFrom: &corev1.EnvVarSource{
+									FieldRef: &corev1.ObjectFieldSelector{
+										APIVersion: "v1",
+										FieldPath:  "metadata.namespace",
+									},
+								},
+							},
+						}, manager.Env...),
+						Command: []string{"/manager"},
+						Args:    append(append([]string{}, manager.Args...), providerArgs(provider)...),
+						Ports: []corev1.ContainerPort{
+							{
+								Name:          "healthz",
+								ContainerPort: 9440,
+								Protocol:      corev1.ProtocolTCP,
+							},
+						},
+						LivenessProbe:  healthzProbe("/healthz"),
+						ReadinessProbe: healthzProbe("/readyz"),
+					},
+				},
+			},
+		},
+	})
+
+	return nil
+}
+
+// healthzProbe probes path on the healthz port of a manager. The values
+// the server would default are spelled out, so that the probe compares equal
+// to the one read back.
+func healthzProbe(path string) *corev1.Probe {
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path:   path,
+				Port:   intstr.FromString("healthz"),
+				Scheme: corev1.URISchemeHTTP,
+			},
+		},
+		TimeoutSeconds:   1,
+		PeriodSeconds:    10,
+		SuccessThreshold: 1,
+		FailureThreshold: 3,
+	}
+}
+
+// setManagerDeploymentSpec copies the fields the operator manages from
+// desired into the spec of a manager Deployment. Fields defaulted by the
+// server, such as the rollout strategy or the termination message path of a
+// container, are kept, so that an unchanged Deployment is not updated on
+// every reconcile.
+func setManagerDeploymentSpec(deployment *appsv1.Deployment, desired appsv1.DeploymentSpec) {
+	spec := &deployment.Spec
+	spec.Replicas = desired.Replicas
+	spec.Selector = desired.Selector
+	spec.Template.Labels = desired.Template.Labels
+
+	podSpec := &spec.Template.Spec
+	desiredPodSpec := desired.Template.Spec
+	podSpec.ServiceAccountName = desiredPodSpec.ServiceAccountName
+	if desiredPodSpec.TerminationGracePeriodSeconds != nil {
+		podSpec.TerminationGracePeriodSeconds = desiredPodSpec.TerminationGracePeriodSeconds
+	}
+	podSpec.Tolerations = desiredPodSpec.Tolerations
+	podSpec.Volumes = mergeVolumes(podSpec.Volumes, desiredPodSpec.Volumes)
+	podSpec.Containers = mergeContainers(podSpec.Containers, desiredPodSpec.Containers)
+}
+
+// mergeVolumes returns the desired volumes, keeping the file modes the
+// server defaulted on the current ones.
+func mergeVolumes(current, desired []corev1.Volume) []corev1.Volume {
+	if len(desired) == 0 {
+		return nil
+	}
+
+	volumes := make([]corev1.Volume, 0, len(desired))
+	for i := range desired {
+		volume := desired[i].DeepCopy()
+		for _, existing := range current {
+			if existing.Name != volume.Name {
+				continue
+			}
+			if volume.Secret != nil && existing.Secret != nil && volume.Secret.DefaultMode == nil {
+				volume.Secret.DefaultMode = existing.Secret.DefaultMode
+			}
+			if volume.Projected != nil && existing.Projected != nil && volume.Projected.DefaultMode == nil {
+				volume.Projected.DefaultMode = existing.Projected.DefaultMode
+			}
+		}
+		volumes = append(volumes, *volume)
+	}
+	return volumes
+}
+
+// mergeContainers returns the desired containers on top of the current ones
+// of the same name, so that the fields the operator does not set keep the
+// values defaulted by the server.
+func mergeContainers(current, desired []corev1.Container) []corev1.Container {
+	containers := make([]corev1.Container, 0, len(desired))
+	for _, d := range desired {
+		container := corev1.Container{}
+		for _, existing := range current {
+			if existing.Name == d.Name {
+				container = *existing.DeepCopy()
+			}
+		}
+		container.Name = d.Name
+		container.Image = d.Image
+		container.ImagePullPolicy = d.ImagePullPolicy
+		container.Command = d.Command
+		container.Args = d.Args
+		container.Env = d.Env
+		container.Ports = d.Ports
+		container.VolumeMounts = d.VolumeMounts
+		container.LivenessProbe = d.LivenessProbe
+		container.ReadinessProbe = d.ReadinessProbe
+		containers = append(containers, container)
+	}
+	return containers
+}
+
+// secretEnvVar returns an environment variable read from a secret key.
+func secretEnvVar(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+// setCredentialsHash stamps the hash of the secrets referenced by the pod
+// template of a Deployment on the template.
+func setCredentialsHash(ctx context.Context, c client.Reader, deployment *appsv1.Deployment) error {
+	hash, err := secretsHash(ctx, c, deployment.Namespace, podSecretNames(deployment.Spec.Template.Spec))
+	if err != nil {
+		return err
+	}
+
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = map[string]string{}
+	}
+	deployment.Spec.Template.Annotations[credentialsHashAnnotation] = hash
+	return nil
+}
+
+// podSecretNames returns the sorted names of the secrets mounted or read
+// into environment variables by a pod.
+func podSecretNames(spec corev1.PodSpec) []string {
+	names := map[string]struct{}{}
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil {
+			names[volume.Secret.SecretName] = struct{}{}
+		}
+	}
+	for _, container := range spec.Containers {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				names[env.ValueFrom.SecretKeyRef.Name] = struct{}{}
+			}
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// secretsHash returns a hash of the contents of secrets. Secrets that do not
+// exist yet hash as empty, the pods are rolled once they are created.
+func secretsHash(ctx context.Context, c client.Reader, namespace string, names []string) (string, error) {
+	hash := sha256.New()
+	for _, name := range names {
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return "", fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+		}
+
+		keys := make([]string, 0, len(secret.Data))
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(hash, "%s\n", name)
+		for _, key := range keys {
+			fmt.Fprintf(hash, "%s=%x\n", key, secret.Data[key])
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
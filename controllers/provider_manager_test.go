@@ -0,0 +1,128 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestSetCredentialsHash(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	deployment := ClusterAPIAWSManagerDeployment("openshift-cluster-api")
+	g.Expect(reconcileCAPIAWSProviderDeployment(deployment, operatorv1.ProviderSpec{}, nil, false)).To(Succeed())
+	g.Expect(podSecretNames(deployment.Spec.Template.Spec)).To(Equal([]string{capaCredentialsSecretName}))
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: capaCredentialsSecretName},
+		Data:       map[string][]byte{"credentials": []byte("old")},
+	}
+	c := newFakeClient(secret)
+
+	g.Expect(setCredentialsHash(ctx, c, deployment)).To(Succeed())
+	oldHash := deployment.Spec.Template.Annotations[credentialsHashAnnotation]
+	g.Expect(oldHash).NotTo(BeEmpty())
+
+	// Reconciling unchanged credentials must not roll the pods.
+	g.Expect(setCredentialsHash(ctx, c, deployment)).To(Succeed())
+	g.Expect(deployment.Spec.Template.Annotations[credentialsHashAnnotation]).To(Equal(oldHash))
+
+	secret.Data["credentials"] = []byte("rotated")
+	g.Expect(c.Update(ctx, secret)).To(Succeed())
+	g.Expect(setCredentialsHash(ctx, c, deployment)).To(Succeed())
+	g.Expect(deployment.Spec.Template.Annotations[credentialsHashAnnotation]).NotTo(Equal(oldHash))
+}
+
+func TestRotateNamespaceCredentials(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	capiDeployment := &operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"}}
+	cloudCredentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: capiDeployment.Namespace, Name: capaCloudCredentialsSecretName},
+		Data: map[string][]byte{
+			"aws_access_key_id":     []byte("AKIAOLD"),
+			"aws_secret_access_key": []byte("old"),
+		},
+	}
+	r := &CAPIDeploymentReconciler{
+		Client:   newFakeClient(capiDeployment, cloudCredentials),
+		Scheme:   testScheme,
+		Recorder: record.NewFakeRecorder(10),
+	}
+	infra := testAWSInfrastructure("us-east-1", nil)
+	provider := &awsProvider{}
+
+	reconcileHash := func() string {
+		g.Expect(provider.ReconcileCredentials(ctx, r.eventingClient(r.Client, capiDeployment), capiDeployment, infra)).To(Succeed())
+		deployment := ClusterAPIAWSManagerDeployment(capiDeployment.Namespace)
+		g.Expect(provider.ReconcileManagerDeployment(deployment, operatorv1.ProviderSpec{}, infra)).To(Succeed())
+		g.Expect(setCredentialsHash(ctx, r.Client, deployment)).To(Succeed())
+		return deployment.Spec.Template.Annotations[credentialsHashAnnotation]
+	}
+
+	oldHash := reconcileHash()
+	g.Expect(oldHash).NotTo(BeEmpty())
+
+	// The cloud credential operator rotates the keys it minted.
+	cloudCredentials.Data["aws_secret_access_key"] = []byte("rotated")
+	g.Expect(r.Client.Update(ctx, cloudCredentials)).To(Succeed())
+
+	// The rotation is watched in the namespace of the CAPIDeployment.
+	p := namespaceCredentialsPredicate()
+	g.Expect(p.Update(event.UpdateEvent{MetaOld: cloudCredentials, ObjectOld: cloudCredentials, MetaNew: cloudCredentials, ObjectNew: cloudCredentials})).To(BeTrue())
+	g.Expect(r.namespaceCredentialsToCAPIDeployments(handler.MapObject{Meta: cloudCredentials, Object: cloudCredentials})).To(ConsistOf(
+		reconcile.Request{NamespacedName: types.NamespacedName{Namespace: capiDeployment.Namespace, Name: capiDeployment.Name}},
+	))
+
+	g.Expect(reconcileHash()).NotTo(Equal(oldHash))
+}
+
+func TestNamespaceCredentialsWatches(t *testing.T) {
+	g := NewWithT(t)
+
+	var watched []string
+	watches := &namespaceWatches{
+		started: map[string]bool{},
+		watch: func(namespace string) error {
+			watched = append(watched, namespace)
+			return nil
+		},
+	}
+	g.Expect(watches.ensure("openshift-cluster-api")).To(Succeed())
+	g.Expect(watches.ensure("openshift-cluster-api")).To(Succeed())
+	g.Expect(watches.ensure("other")).To(Succeed())
+	g.Expect(watched).To(Equal([]string{"openshift-cluster-api", "other"}))
+
+	// Only the minted credentials are watched, not every secret of the
+	// namespace.
+	p := namespaceCredentialsPredicate()
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: capaCredentialsSecretName}}
+	g.Expect(p.Create(event.CreateEvent{Meta: secret, Object: secret})).To(BeFalse())
+}
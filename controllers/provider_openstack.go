@@ -0,0 +1,205 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// capoCloudsSecretName is the secret the OpenStackCluster references for
+	// its clouds.yaml.
+	capoCloudsSecretName = "capo-cloud-config"
+
+	defaultOpenStackCloudName = "openstack"
+)
+
+// openstackCredentialsSecret holds the clouds.yaml the installer created for
+// the cluster.
+var openstackCredentialsSecret = types.NamespacedName{Namespace: cloudCredentialsNamespace, Name: "openstack-credentials"}
+
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=openstackclusters,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=openstackclusters/status,verbs=get;update;patch
+
+// openstackProvider deploys the Cluster API OpenStack provider (CAPO).
+type openstackProvider struct{}
+
+var _ infrastructureProvider = &openstackProvider{}
+
+func getOpenStackCloudName(infra *clusterInfrastructure) string {
+	if infra.Status.PlatformStatus == nil || infra.Status.PlatformStatus.OpenStack == nil ||
+		infra.Status.PlatformStatus.OpenStack.CloudName == "" {
+		return defaultOpenStackCloudName
+	}
+	return infra.Status.PlatformStatus.OpenStack.CloudName
+}
+
+func (p *openstackProvider) ValidatePlatformStatus(infra *clusterInfrastructure) error {
+	if infra.Status.InfrastructureName == "" {
+		return fmt.Errorf("infrastructure %q has no infrastructure name", infra.Name)
+	}
+	if _, err := getControlPlaneEndpoint(infra); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *openstackProvider) InfrastructureCluster(name, namespace string) controllerutil.Object {
+	return CAPOCluster(name, namespace)
+}
+
+func (p *openstackProvider) ReconcileInfrastructureCluster(infraCluster controllerutil.Object, infra *clusterInfrastructure) error {
+	endpoint, err := getControlPlaneEndpoint(infra)
+	if err != nil {
+		return err
+	}
+	return setInfrastructureClusterSpec(infraCluster, openstackClusterSpec(infra, infraCluster.GetNamespace(), endpoint))
+}
+
+// ReconcileInfrastructureClusterStatus marks the OpenStackCluster ready, the
+// network it points at was created by the installer.
+func (p *openstackProvider) ReconcileInfrastructureClusterStatus(ctx context.Context, c client.Client, infraCluster controllerutil.Object) error {
+	return markInfrastructureClusterReady(ctx, c, infraCluster)
+}
+
+func (p *openstackProvider) InfrastructureClusterReady(infraCluster controllerutil.Object) (bool, error) {
+	return unstructuredInfrastructureClusterReady(infraCluster)
+}
+
+func (p *openstackProvider) ManagerDeployment(namespace string) *appsv1.Deployment {
+	return providerManagerDeployment(capoManager, namespace)
+}
+
+func (p *openstackProvider) ReconcileManagerDeployment(deployment *appsv1.Deployment, provider operatorv1.ProviderSpec, infra *clusterInfrastructure) error {
+	return reconcileProviderManagerDeployment(deployment, capoManager, provider)
+}
+
+func (p *openstackProvider) ManagerRBAC() managerRBAC {
+	return capoManagerRBAC
+}
+
+// ReconcileCredentials copies the cluster's clouds.yaml, and its CA bundle
+// when there is one, into the secret the OpenStackCluster references.
+func (p *openstackProvider) ReconcileCredentials(ctx context.Context, c *eventingClient, capiDeployment *operatorv1.CAPIDeployment, infra *clusterInfrastructure) error {
+	namespace := capiDeployment.Namespace
+	target := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      capoCloudsSecretName,
+		},
+	}
+	return renderCredentialsSecret(ctx, c, capiDeployment, openstackCredentialsSecret, target, func(data map[string][]byte) (map[string][]byte, error) {
+		clouds, ok := data["clouds.yaml"]
+		if !ok {
+			return nil, fmt.Errorf("credentials secret %s has no %q key", openstackCredentialsSecret, "clouds.yaml")
+		}
+
+		rendered := map[string][]byte{"clouds.yaml": clouds}
+		if cacert, ok := data["cacert"]; ok {
+			rendered["cacert"] = cacert
+		}
+		return rendered, nil
+	})
+}
+
+func (p *openstackProvider) DeleteCredentials(ctx context.Context, c client.Client, namespace string) error {
+	return deleteCredentialsSecret(ctx, c, namespace, capoCloudsSecretName)
+}
+
+func CAPOCluster(name, namespace string) controllerutil.Object {
+	return newInfrastructureCluster("OpenStackCluster", name, namespace)
+}
+
+// openstackClusterSpec points the OpenStackCluster at the network and subnet
+// the installer created. Leaving nodeCidr empty and disabling the managed
+// load balancer and security groups stops CAPO from creating its own.
+func openstackClusterSpec(infra *clusterInfrastructure, namespace string, endpoint clusterv1.APIEndpoint) map[string]interface{} {
+	infraName := infra.Status.InfrastructureName
+
+	return map[string]interface{}{
+		"cloudName": getOpenStackCloudName(infra),
+		"cloudsSecret": map[string]interface{}{
+			"name":      capoCloudsSecretName,
+			"namespace": namespace,
+		},
+		"network": map[string]interface{}{
+			"name": infraName + "-openshift",
+		},
+		"subnet": map[string]interface{}{
+			"name": infraName + "-nodes",
+		},
+		"managedAPIServerLoadBalancer": false,
+		"managedSecurityGroups":        false,
+		"controlPlaneEndpoint": map[string]interface{}{
+			"host": endpoint.Host,
+			"port": int64(endpoint.Port),
+		},
+	}
+}
+
+// capoManager is the CAPO manager Deployment. CAPO reads its credentials
+// from the secret referenced by each OpenStackCluster.
+var capoManager = providerManager{
+	Name:               "capo-controller-manager",
+	ProviderName:       operatorv1.OpenStackProviderName,
+	ServiceAccountName: capoManagerRBAC.ServiceAccountName,
+}
+
+// capoManagerRBAC grants the permissions of the CAPO v0.3 manager. CAPO
+// needs nothing cluster scoped.
+var capoManagerRBAC = managerRBAC{
+	ServiceAccountName: "capo-controller-manager",
+	RoleName:           "capo-manager",
+	Rules: []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"cluster.x-k8s.io"},
+			Resources: []string{"clusters", "clusters/status", "machines", "machines/status"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{"openstackclusters", "openstackmachines", "openstackmachinetemplates"},
+			Verbs:     allVerbs,
+		},
+		{
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{"openstackclusters/status", "openstackmachines/status"},
+			Verbs:     statusVerbs,
+		},
+	},
+}
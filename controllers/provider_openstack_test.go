@@ -0,0 +1,146 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+)
+
+func testOpenStackInfrastructure(openstack *configv1.OpenStackPlatformStatus) *clusterInfrastructure {
+	return &clusterInfrastructure{
+		Infrastructure: &configv1.Infrastructure{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Status: configv1.InfrastructureStatus{
+				InfrastructureName:   "test-abcde",
+				APIServerInternalURL: "https://api-int.test.example.com:6443",
+				PlatformStatus: &configv1.PlatformStatus{
+					Type:      configv1.OpenStackPlatformType,
+					OpenStack: openstack,
+				},
+			},
+		},
+	}
+}
+
+func TestGetOpenStackCloudName(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(getOpenStackCloudName(testOpenStackInfrastructure(nil))).To(Equal(defaultOpenStackCloudName))
+	g.Expect(getOpenStackCloudName(testOpenStackInfrastructure(&configv1.OpenStackPlatformStatus{}))).To(Equal(defaultOpenStackCloudName))
+	g.Expect(getOpenStackCloudName(testOpenStackInfrastructure(&configv1.OpenStackPlatformStatus{CloudName: "shiftstack"}))).To(Equal("shiftstack"))
+}
+
+func TestOpenStackValidatePlatformStatus(t *testing.T) {
+	g := NewWithT(t)
+
+	provider := &openstackProvider{}
+	g.Expect(provider.ValidatePlatformStatus(testOpenStackInfrastructure(nil))).To(Succeed())
+
+	infra := testOpenStackInfrastructure(nil)
+	infra.Status.InfrastructureName = ""
+	g.Expect(provider.ValidatePlatformStatus(infra)).NotTo(Succeed())
+
+	infra = testOpenStackInfrastructure(nil)
+	infra.Status.APIServerInternalURL = ""
+	g.Expect(provider.ValidatePlatformStatus(infra)).NotTo(Succeed())
+}
+
+func TestOpenStackClusterSpec(t *testing.T) {
+	g := NewWithT(t)
+
+	infra := testOpenStackInfrastructure(&configv1.OpenStackPlatformStatus{CloudName: "shiftstack"})
+	endpoint := clusterv1.APIEndpoint{Host: "api-int.test.example.com", Port: 6443}
+	g.Expect(openstackClusterSpec(infra, "openshift-cluster-api", endpoint)).To(Equal(map[string]interface{}{
+		"cloudName": "shiftstack",
+		"cloudsSecret": map[string]interface{}{
+			"name":      capoCloudsSecretName,
+			"namespace": "openshift-cluster-api",
+		},
+		"network": map[string]interface{}{
+			"name": "test-abcde-openshift",
+		},
+		"subnet": map[string]interface{}{
+			"name": "test-abcde-nodes",
+		},
+		"managedAPIServerLoadBalancer": false,
+		"managedSecurityGroups":        false,
+		"controlPlaneEndpoint": map[string]interface{}{
+			"host": "api-int.test.example.com",
+			"port": int64(6443),
+		},
+	}))
+}
+
+func TestReconcileOpenStackCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        map[string][]byte
+		expected    map[string][]byte
+		expectError bool
+	}{
+		{
+			name:     "clouds.yaml",
+			data:     map[string][]byte{"clouds.yaml": []byte("clouds: {}\n"), "other": []byte("ignored")},
+			expected: map[string][]byte{"clouds.yaml": []byte("clouds: {}\n")},
+		},
+		{
+			name:     "clouds.yaml and CA bundle",
+			data:     map[string][]byte{"clouds.yaml": []byte("clouds: {}\n"), "cacert": []byte("-----BEGIN CERTIFICATE-----")},
+			expected: map[string][]byte{"clouds.yaml": []byte("clouds: {}\n"), "cacert": []byte("-----BEGIN CERTIFICATE-----")},
+		},
+		{
+			name:        "no clouds.yaml",
+			data:        map[string][]byte{"cacert": []byte("-----BEGIN CERTIFICATE-----")},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			capiDeployment := &operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"}}
+			source := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: openstackCredentialsSecret.Namespace, Name: openstackCredentialsSecret.Name},
+				Data:       tt.data,
+			}
+			c := &eventingClient{Client: newFakeClient(source), recorder: record.NewFakeRecorder(10), scheme: testScheme, owner: capiDeployment}
+
+			err := new(openstackProvider).ReconcileCredentials(ctx, c, capiDeployment, testOpenStackInfrastructure(nil))
+			if tt.expectError {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+
+			target := &corev1.Secret{}
+			g.Expect(c.Get(ctx, types.NamespacedName{Namespace: capiDeployment.Namespace, Name: capoCloudsSecretName}, target)).To(Succeed())
+			g.Expect(target.Data).To(Equal(tt.expected))
+		})
+	}
+}
@@ -0,0 +1,300 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// capvCredentialsSecretName is the secret holding the credentials file
+	// mounted into the CAPV manager.
+	capvCredentialsSecretName = "capv-manager-bootstrap-credentials"
+)
+
+// defaultVSphereCredentialsSecret is used when the cloud provider config
+// does not name the secret holding the vCenter credentials.
+var defaultVSphereCredentialsSecret = types.NamespacedName{Namespace: cloudCredentialsNamespace, Name: "vsphere-creds"}
+
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=vsphereclusters,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=vsphereclusters/status,verbs=get;update;patch
+
+// vsphereProvider deploys the Cluster API vSphere provider (CAPV).
+type vsphereProvider struct{}
+
+var _ infrastructureProvider = &vsphereProvider{}
+
+// vsphereCloudConfig is the part of the vSphere cloud provider config that
+// identifies the vCenter and its credentials.
+type vsphereCloudConfig struct {
+	Server            string
+	CredentialsSecret types.NamespacedName
+}
+
+// parseVSphereCloudConfig reads the INI formatted vSphere cloud provider
+// config. Only the keys the operator needs are looked at.
+func parseVSphereCloudConfig(config string) (*vsphereCloudConfig, error) {
+	cloudConfig := &vsphereCloudConfig{CredentialsSecret: defaultVSphereCredentialsSecret}
+	var virtualCenters []string
+
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(config))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if strings.HasPrefix(section, "VirtualCenter ") {
+				virtualCenters = append(virtualCenters, strings.Trim(strings.TrimPrefix(section, "VirtualCenter "), `" `))
+			}
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid vSphere cloud provider config line %q", line)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.Trim(strings.TrimSpace(kv[1]), `"`)
+
+		switch {
+		case section == "Workspace" && key == "server":
+			cloudConfig.Server = value
+		case section == "Global" && key == "secret-name":
+			cloudConfig.CredentialsSecret.Name = value
+		case section == "Global" && key == "secret-namespace":
+			cloudConfig.CredentialsSecret.Namespace = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read vSphere cloud provider config: %w", err)
+	}
+
+	if cloudConfig.Server == "" && len(virtualCenters) > 0 {
+		cloudConfig.Server = virtualCenters[0]
+	}
+	if cloudConfig.Server == "" {
+		return nil, fmt.Errorf("vSphere cloud provider config names no vCenter server")
+	}
+
+	return cloudConfig, nil
+}
+
+func getVSphereCloudConfig(infra *clusterInfrastructure) (*vsphereCloudConfig, error) {
+	if infra.CloudConfig == "" {
+		return nil, fmt.Errorf("infrastructure %q references no cloud provider config", infra.Name)
+	}
+	return parseVSphereCloudConfig(infra.CloudConfig)
+}
+
+func (p *vsphereProvider) ValidatePlatformStatus(infra *clusterInfrastructure) error {
+	if _, err := getVSphereCloudConfig(infra); err != nil {
+		return err
+	}
+	if _, err := getControlPlaneEndpoint(infra); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *vsphereProvider) InfrastructureCluster(name, namespace string) controllerutil.Object {
+	return CAPVCluster(name, namespace)
+}
+
+func (p *vsphereProvider) ReconcileInfrastructureCluster(infraCluster controllerutil.Object, infra *clusterInfrastructure) error {
+	cloudConfig, err := getVSphereCloudConfig(infra)
+	if err != nil {
+		return err
+	}
+	endpoint, err := getControlPlaneEndpoint(infra)
+	if err != nil {
+		return err
+	}
+
+	return setInfrastructureClusterSpec(infraCluster, map[string]interface{}{
+		"server": cloudConfig.Server,
+		"controlPlaneEndpoint": map[string]interface{}{
+			"host": endpoint.Host,
+			"port": int64(endpoint.Port),
+		},
+	})
+}
+
+// ReconcileInfrastructureClusterStatus marks the VSphereCluster ready, the
+// control plane it points at was created by the installer.
+func (p *vsphereProvider) ReconcileInfrastructureClusterStatus(ctx context.Context, c client.Client, infraCluster controllerutil.Object) error {
+	return markInfrastructureClusterReady(ctx, c, infraCluster)
+}
+
+func (p *vsphereProvider) InfrastructureClusterReady(infraCluster controllerutil.Object) (bool, error) {
+	return unstructuredInfrastructureClusterReady(infraCluster)
+}
+
+func (p *vsphereProvider) ManagerDeployment(namespace string) *appsv1.Deployment {
+	return providerManagerDeployment(capvManager, namespace)
+}
+
+func (p *vsphereProvider) ReconcileManagerDeployment(deployment *appsv1.Deployment, provider operatorv1.ProviderSpec, infra *clusterInfrastructure) error {
+	return reconcileProviderManagerDeployment(deployment, capvManager, provider)
+}
+
+func (p *vsphereProvider) ManagerRBAC() managerRBAC {
+	return capvManagerRBAC
+}
+
+// ReconcileCredentials renders the vCenter credentials of the cluster into
+// the credentials file read by the CAPV manager.
+func (p *vsphereProvider) ReconcileCredentials(ctx context.Context, c *eventingClient, capiDeployment *operatorv1.CAPIDeployment, infra *clusterInfrastructure) error {
+	namespace := capiDeployment.Namespace
+	cloudConfig, err := getVSphereCloudConfig(infra)
+	if err != nil {
+		return err
+	}
+
+	target := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      capvCredentialsSecretName,
+		},
+	}
+	return renderCredentialsSecret(ctx, c, capiDeployment, cloudConfig.CredentialsSecret, target, func(data map[string][]byte) (map[string][]byte, error) {
+		return renderVSphereCredentials(cloudConfig, data)
+	})
+}
+
+// renderVSphereCredentials turns the per-server keys of the cluster's
+// credentials secret into the credentials file format CAPV reads.
+func renderVSphereCredentials(cloudConfig *vsphereCloudConfig, data map[string][]byte) (map[string][]byte, error) {
+	username, ok := data[cloudConfig.Server+".username"]
+	if !ok {
+		return nil, fmt.Errorf("credentials secret %s has no username for %s", cloudConfig.CredentialsSecret, cloudConfig.Server)
+	}
+	password, ok := data[cloudConfig.Server+".password"]
+	if !ok {
+		return nil, fmt.Errorf("credentials secret %s has no password for %s", cloudConfig.CredentialsSecret, cloudConfig.Server)
+	}
+
+	credentials, err := yaml.Marshal(map[string]string{
+		"username": string(username),
+		"password": string(password),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render vSphere credentials: %w", err)
+	}
+
+	return map[string][]byte{"credentials.yaml": credentials}, nil
+}
+
+func (p *vsphereProvider) DeleteCredentials(ctx context.Context, c client.Client, namespace string) error {
+	return deleteCredentialsSecret(ctx, c, namespace, capvCredentialsSecretName)
+}
+
+func CAPVCluster(name, namespace string) controllerutil.Object {
+	return newInfrastructureCluster("VSphereCluster", name, namespace)
+}
+
+// capvManager is the CAPV manager Deployment. CAPV reads its credentials
+// from /etc/capv/credentials.yaml.
+var capvManager = providerManager{
+	Name:               "capv-controller-manager",
+	ProviderName:       operatorv1.VSphereProviderName,
+	ServiceAccountName: capvManagerRBAC.ServiceAccountName,
+	Volumes: []corev1.Volume{
+		{
+			Name: "credentials",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: capvCredentialsSecretName,
+				},
+			},
+		},
+	},
+	VolumeMounts: []corev1.VolumeMount{
+		{
+			Name:      "credentials",
+			MountPath: "/etc/capv",
+		},
+	},
+}
+
+// capvManagerRBAC grants the permissions of the CAPV v0.7 manager.
+var capvManagerRBAC = managerRBAC{
+	ServiceAccountName: "capv-controller-manager",
+	RoleName:           "capv-manager",
+	ClusterRoleName:    "cluster-api-vsphere",
+	Rules: []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps", "secrets"},
+			Verbs:     allVerbs,
+		},
+		{
+			APIGroups: []string{"cluster.x-k8s.io"},
+			Resources: []string{"clusters", "clusters/status", "machines", "machines/status"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{
+				"haproxyloadbalancers",
+				"vsphereclusters",
+				"vspheremachines",
+				"vspheremachinetemplates",
+				"vspherevms",
+			},
+			Verbs: allVerbs,
+		},
+		{
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{
+				"haproxyloadbalancers/status",
+				"vsphereclusters/status",
+				"vspheremachines/status",
+				"vspherevms/status",
+			},
+			Verbs: statusVerbs,
+		},
+	},
+	ClusterRules: []rbacv1.PolicyRule{
+		{
+			// vSphere identities are cluster scoped.
+			APIGroups: []string{"infrastructure.cluster.x-k8s.io"},
+			Resources: []string{"vsphereclusteridentities", "vsphereclusteridentities/status"},
+			Verbs:     readVerbs,
+		},
+	},
+}
@@ -0,0 +1,179 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestParseVSphereCloudConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		cloudConfig string
+		expected    *vsphereCloudConfig
+		expectError bool
+	}{
+		{
+			name: "workspace server",
+			cloudConfig: `[Global]
+secret-name = "vsphere-creds"
+secret-namespace = "kube-system"
+insecure-flag = "1"
+
+[Workspace]
+server = "vcenter.example.com"
+datacenter = "dc1"
+default-datastore = "ds1"
+folder = "/dc1/vm/test-abcde"
+
+[VirtualCenter "vcenter.example.com"]
+datacenters = "dc1"
+`,
+			expected: &vsphereCloudConfig{
+				Server:            "vcenter.example.com",
+				CredentialsSecret: types.NamespacedName{Namespace: "kube-system", Name: "vsphere-creds"},
+			},
+		},
+		{
+			name: "virtual center fallback and custom secret",
+			cloudConfig: `; comment
+# comment
+[Global]
+secret-name = custom-creds
+secret-namespace = openshift-config
+
+[VirtualCenter "vcenter1.example.com"]
+datacenters = "dc1"
+
+[VirtualCenter "vcenter2.example.com"]
+datacenters = "dc2"
+`,
+			expected: &vsphereCloudConfig{
+				Server:            "vcenter1.example.com",
+				CredentialsSecret: types.NamespacedName{Namespace: "openshift-config", Name: "custom-creds"},
+			},
+		},
+		{
+			name:        "default secret",
+			cloudConfig: "[Workspace]\nserver = vcenter.example.com\n",
+			expected: &vsphereCloudConfig{
+				Server:            "vcenter.example.com",
+				CredentialsSecret: defaultVSphereCredentialsSecret,
+			},
+		},
+		{
+			name:        "no server",
+			cloudConfig: "[Global]\nsecret-name = vsphere-creds\n",
+			expectError: true,
+		},
+		{
+			name:        "invalid line",
+			cloudConfig: "[Workspace]\nserver\n",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cloudConfig, err := parseVSphereCloudConfig(tt.cloudConfig)
+			if tt.expectError {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(cloudConfig).To(Equal(tt.expected))
+		})
+	}
+}
+
+func testVSphereInfrastructure(cloudConfig string) *clusterInfrastructure {
+	return &clusterInfrastructure{
+		Infrastructure: &configv1.Infrastructure{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Status: configv1.InfrastructureStatus{
+				InfrastructureName:   "test-abcde",
+				APIServerInternalURL: "https://api-int.test.example.com:6443",
+				PlatformStatus:       &configv1.PlatformStatus{Type: configv1.VSpherePlatformType},
+			},
+		},
+		CloudConfig: cloudConfig,
+	}
+}
+
+func TestReconcileVSphereCluster(t *testing.T) {
+	g := NewWithT(t)
+
+	provider := &vsphereProvider{}
+	infra := testVSphereInfrastructure("[Workspace]\nserver = vcenter.example.com\n")
+	g.Expect(provider.ValidatePlatformStatus(infra)).To(Succeed())
+
+	infraCluster := provider.InfrastructureCluster("cluster", "openshift-cluster-api")
+	g.Expect(provider.ReconcileInfrastructureCluster(infraCluster, infra)).To(Succeed())
+	spec, _, _ := unstructured.NestedMap(infraCluster.(*unstructured.Unstructured).Object, "spec")
+	g.Expect(spec).To(Equal(map[string]interface{}{
+		"server": "vcenter.example.com",
+		"controlPlaneEndpoint": map[string]interface{}{
+			"host": "api-int.test.example.com",
+			"port": int64(6443),
+		},
+	}))
+
+	g.Expect(provider.ValidatePlatformStatus(testVSphereInfrastructure(""))).NotTo(Succeed())
+}
+
+func TestReconcileVSphereCredentials(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	capiDeployment := &operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"}}
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "vsphere-creds"},
+		Data: map[string][]byte{
+			"vcenter.example.com.username": []byte("administrator@vsphere.local"),
+			"vcenter.example.com.password": []byte("secret"),
+			// Credentials of another vCenter are not copied.
+			"other.example.com.username": []byte("other"),
+			"other.example.com.password": []byte("other"),
+		},
+	}
+	c := &eventingClient{Client: newFakeClient(source), recorder: record.NewFakeRecorder(10), scheme: testScheme, owner: capiDeployment}
+	infra := testVSphereInfrastructure("[Global]\nsecret-name = vsphere-creds\nsecret-namespace = kube-system\n[Workspace]\nserver = vcenter.example.com\n")
+
+	g.Expect(new(vsphereProvider).ReconcileCredentials(ctx, c, capiDeployment, infra)).To(Succeed())
+
+	target := &corev1.Secret{}
+	g.Expect(c.Get(ctx, types.NamespacedName{Namespace: capiDeployment.Namespace, Name: capvCredentialsSecretName}, target)).To(Succeed())
+	g.Expect(target.Data).To(HaveLen(1))
+	g.Expect(target.Data).To(HaveKeyWithValue("credentials.yaml", []byte("password: secret\nusername: administrator@vsphere.local\n")))
+
+	// The credentials of the configured server are required.
+	delete(source.Data, "vcenter.example.com.password")
+	g.Expect(c.Update(ctx, source)).To(Succeed())
+	g.Expect(new(vsphereProvider).ReconcileCredentials(ctx, c, capiDeployment, infra)).NotTo(Succeed())
+}
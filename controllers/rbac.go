@@ -0,0 +1,429 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// The provider managers run with --namespace, so everything they touch on
+// their own cluster lives in the CAPIDeployment namespace and is granted
+// through a namespaced Role. The ClusterRoles only carry what is cluster
+// scoped. Rules mirror the upstream manager roles of the provider versions
+// deployed by default.
+
+var (
+	readVerbs   = []string{"get", "list", "watch"}
+	statusVerbs = []string{"get", "update", "patch"}
+	allVerbs    = []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+)
+
+// leaderElectionRules let the replicas of a manager elect a leader in the
+// CAPIDeployment namespace, through a ConfigMap or a Lease lock.
+var leaderElectionRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{"configmaps"},
+		Verbs:     allVerbs,
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"configmaps/status"},
+		Verbs:     statusVerbs,
+	},
+	{
+		APIGroups: []string{"coordination.k8s.io"},
+		Resources: []string{"leases"},
+		Verbs:     allVerbs,
+	},
+}
+
+// managerRBAC describes the identity and permissions of a provider manager.
+type managerRBAC struct {
+	// ServiceAccountName is the ServiceAccount the manager runs as.
+	ServiceAccountName string
+	// RoleName names the Role and RoleBinding in the CAPIDeployment namespace.
+	RoleName string
+	// ClusterRoleName names the ClusterRole, which is shared by every
+	// CAPIDeployment, and suffixes the ClusterRoleBinding of each namespace.
+	// Unused when there are no ClusterRules.
+	ClusterRoleName string
+	// Rules are granted in the CAPIDeployment namespace.
+	Rules []rbacv1.PolicyRule
+	// ClusterRules are granted cluster wide.
+	ClusterRules []rbacv1.PolicyRule
+}
+
+// capiManagerRBAC grants the permissions of the core CAPI v0.3 manager.
+var capiManagerRBAC = managerRBAC{
+	ServiceAccountName: "capi-controller-manager",
+	RoleName:           "capi-manager",
+	ClusterRoleName:    "cluster-api",
+	Rules: []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"get", "list", "watch", "patch"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"get", "list", "watch", "create", "patch"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     []string{"get", "list", "watch", "create", "patch"},
+		},
+		{
+			APIGroups: []string{"cluster.x-k8s.io"},
+			Resources: []string{
+				"clusters", "clusters/status",
+				"machinedeployments", "machinedeployments/status",
+				"machines", "machines/status",
+				"machinesets", "machinesets/status",
+			},
+			Verbs: allVerbs,
+		},
+		{
+			APIGroups: []string{"cluster.x-k8s.io"},
+			Resources: []string{"machinehealthchecks", "machinehealthchecks/status"},
+			Verbs:     []string{"get", "list", "watch", "update", "patch"},
+		},
+		{
+			APIGroups: []string{"exp.cluster.x-k8s.io"},
+			Resources: []string{"machinepools", "machinepools/status"},
+			Verbs:     allVerbs,
+		},
+		{
+			APIGroups: []string{"addons.cluster.x-k8s.io"},
+			Resources: []string{"clusterresourcesets", "clusterresourcesetbindings"},
+			Verbs:     allVerbs,
+		},
+		{
+			APIGroups: []string{"addons.cluster.x-k8s.io"},
+			Resources: []string{"clusterresourcesets/status"},
+			Verbs:     statusVerbs,
+		},
+		{
+			// Core CAPI follows references to arbitrary provider objects.
+			APIGroups: []string{
+				"bootstrap.cluster.x-k8s.io",
+				"controlplane.cluster.x-k8s.io",
+				"infrastructure.cluster.x-k8s.io",
+				"exp.infrastructure.cluster.x-k8s.io",
+			},
+			Resources: []string{"*"},
+			Verbs:     allVerbs,
+		},
+	},
+	ClusterRules: []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{"apiextensions.k8s.io"},
+			Resources: []string{"customresourcedefinitions"},
+			Verbs:     readVerbs,
+		},
+	},
+}
+
+func managerServiceAccount(rbac managerRBAC, namespace string) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      rbac.ServiceAccountName,
+		},
+	}
+}
+
+func managerClusterRole(rbac managerRBAC) *rbacv1.ClusterRole {
+	return &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: rbac.ClusterRoleName,
+		},
+	}
+}
+
+func reconcileManagerClusterRole(role *rbacv1.ClusterRole, rbac managerRBAC) error {
+	role.Rules = rbac.ClusterRules
+	return nil
+}
+
+// managerClusterRoleBinding returns the ClusterRoleBinding of a manager in a
+// namespace. Each namespace gets its own binding, so CAPIDeployments in
+// different namespaces do not take the binding over from each other.
+func managerClusterRoleBinding(rbac managerRBAC, namespace string) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespace + "-" + rbac.ClusterRoleName,
+		},
+	}
+}
+
+// legacyManagerClusterRoleBinding returns the ClusterRoleBinding earlier
+// versions of the operator shared between all namespaces.
+func legacyManagerClusterRoleBinding(rbac managerRBAC) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: rbac.ClusterRoleName,
+		},
+	}
+}
+
+func reconcileManagerClusterRoleBinding(binding *rbacv1.ClusterRoleBinding, rbac managerRBAC, capiDeployment *operatorv1.CAPIDeployment) error {
+	if binding.Labels == nil {
+		binding.Labels = map[string]string{}
+	}
+	binding.Labels[operatorv1.OwnerNamespaceLabel] = capiDeployment.Namespace
+	binding.Labels[operatorv1.OwnerNameLabel] = capiDeployment.Name
+
+	namespace := capiDeployment.Namespace
+	binding.Subjects = []rbacv1.Subject{
+		{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      rbac.ServiceAccountName,
+			Namespace: namespace,
+		},
+	}
+	binding.RoleRef = rbacv1.RoleRef{
+		APIGroup: rbacv1.GroupName,
+		Kind:     "ClusterRole",
+		Name:     rbac.ClusterRoleName,
+	}
+	return nil
+}
+
+func managerRole(rbac managerRBAC, namespace string) *rbacv1.Role {
+	return &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      rbac.RoleName,
+		},
+	}
+}
+
+func reconcileManagerRole(role *rbacv1.Role, rbac managerRBAC) error {
+	role.Rules = append(append([]rbacv1.PolicyRule{}, rbac.Rules...), leaderElectionRules...)
+	return nil
+}
+
+func managerRoleBinding(rbac managerRBAC, namespace string) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      rbac.RoleName,
+		},
+	}
+}
+
+func reconcileManagerRoleBinding(binding *rbacv1.RoleBinding, rbac managerRBAC, namespace string) error {
+	binding.Subjects = []rbacv1.Subject{
+		{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      rbac.ServiceAccountName,
+			Namespace: namespace,
+		},
+	}
+	binding.RoleRef = rbacv1.RoleRef{
+		APIGroup: rbacv1.GroupName,
+		Kind:     "Role",
+		Name:     rbac.RoleName,
+	}
+	return nil
+}
+
+func (r *CAPIDeploymentReconciler) reconcileManagerRBAC(ctx context.Context, capiDeployment *operatorv1.CAPIDeployment, rbac managerRBAC) error {
+	namespace := capiDeployment.Namespace
+
+	serviceAccount := managerServiceAccount(rbac, namespace)
+	_, err := r.createOrUpdate(ctx, capiDeployment, serviceAccount, func() error {
+		return controllerutil.SetControllerReference(capiDeployment, serviceAccount, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reconcile service account %s: %w", serviceAccount.Name, err)
+	}
+
+	// Managers that need nothing cluster scoped get no ClusterRole at all.
+	if len(rbac.ClusterRules) > 0 {
+		clusterRole := managerClusterRole(rbac)
+		_, err = r.createOrUpdate(ctx, capiDeployment, clusterRole, func() error {
+			return reconcileManagerClusterRole(clusterRole, rbac)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to reconcile cluster role %s: %w", clusterRole.Name, err)
+		}
+
+		clusterRoleBinding := managerClusterRoleBinding(rbac, namespace)
+		err = r.deleteClusterRoleBindingWithStaleRoleRef(ctx, clusterRoleBinding, rbac.ClusterRoleName)
+		if err != nil {
+			return fmt.Errorf("failed to replace cluster role binding %s: %w", clusterRoleBinding.Name, err)
+		}
+		_, err = r.createOrUpdate(ctx, capiDeployment, clusterRoleBinding, func() error {
+			return reconcileManagerClusterRoleBinding(clusterRoleBinding, rbac, capiDeployment)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to reconcile cluster role binding %s: %w", clusterRoleBinding.Name, err)
+		}
+
+		legacyBinding := legacyManagerClusterRoleBinding(rbac)
+		if err := r.deleteClusterRoleBinding(ctx, legacyBinding, namespace); err != nil {
+			return fmt.Errorf("failed to delete cluster role binding %s: %w", legacyBinding.Name, err)
+		}
+	}
+
+	role := managerRole(rbac, namespace)
+	_, err = r.createOrUpdate(ctx, capiDeployment, role, func() error {
+		if err := controllerutil.SetControllerReference(capiDeployment, role, r.Scheme); err != nil {
+			return err
+		}
+		return reconcileManagerRole(role, rbac)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reconcile role %s: %w", role.Name, err)
+	}
+
+	roleBinding := managerRoleBinding(rbac, namespace)
+	_, err = r.createOrUpdate(ctx, capiDeployment, roleBinding, func() error {
+		if err := controllerutil.SetControllerReference(capiDeployment, roleBinding, r.Scheme); err != nil {
+			return err
+		}
+		return reconcileManagerRoleBinding(roleBinding, rbac, namespace)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reconcile role binding %s: %w", roleBinding.Name, err)
+	}
+
+	return nil
+}
+
+// deleteManagerRBAC removes the bindings and namespaced objects of a
+// provider manager. The ClusterRoles are shared by every CAPIDeployment and
+// are left in place.
+func (r *CAPIDeploymentReconciler) deleteManagerRBAC(ctx context.Context, rbac managerRBAC, namespace string) error {
+	for _, binding := range []*rbacv1.ClusterRoleBinding{
+		managerClusterRoleBinding(rbac, namespace),
+		legacyManagerClusterRoleBinding(rbac),
+	} {
+		if err := r.deleteClusterRoleBinding(ctx, binding, namespace); err != nil {
+			return fmt.Errorf("failed to delete cluster role binding %s: %w", binding.Name, err)
+		}
+	}
+
+	for _, obj := range []controllerutil.Object{
+		managerRoleBinding(rbac, namespace),
+		managerRole(rbac, namespace),
+		managerServiceAccount(rbac, namespace),
+	} {
+		if _, err := r.deleteAndCheckGone(ctx, obj); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", obj.GetName(), err)
+		}
+	}
+
+	return nil
+}
+
+// deleteOrphanedClusterRoleBindings removes the ClusterRoleBindings created
+// for a CAPIDeployment that no longer exists, e.g. after it was deleted
+// without its finalizer running or was recreated under another name, and
+// the bindings of the given CAPIDeployment that its current providers no
+// longer use.
+func (r *CAPIDeploymentReconciler) deleteOrphanedClusterRoleBindings(ctx context.Context, capiDeployment *operatorv1.CAPIDeployment, rbacs []managerRBAC) error {
+	expected := map[string]struct{}{}
+	for _, rbac := range rbacs {
+		if len(rbac.ClusterRules) > 0 {
+			expected[managerClusterRoleBinding(rbac, capiDeployment.Namespace).Name] = struct{}{}
+		}
+	}
+
+	bindings := &rbacv1.ClusterRoleBindingList{}
+	if err := r.Client.List(ctx, bindings, client.HasLabels{operatorv1.OwnerNamespaceLabel, operatorv1.OwnerNameLabel}); err != nil {
+		return fmt.Errorf("failed to list cluster role bindings: %w", err)
+	}
+
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		owner := types.NamespacedName{
+			Namespace: binding.Labels[operatorv1.OwnerNamespaceLabel],
+			Name:      binding.Labels[operatorv1.OwnerNameLabel],
+		}
+
+		if owner.Namespace == capiDeployment.Namespace && owner.Name == capiDeployment.Name {
+			if _, ok := expected[binding.Name]; ok {
+				continue
+			}
+		} else {
+			err := r.Client.Get(ctx, owner, &operatorv1.CAPIDeployment{})
+			if err == nil {
+				continue
+			}
+			if !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to get owner of cluster role binding %s: %w", binding.Name, err)
+			}
+		}
+
+		r.Log.Info("Deleting orphaned cluster role binding", "name", binding.Name, "owner", owner)
+		if err := r.Client.Delete(ctx, binding); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete cluster role binding %s: %w", binding.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteClusterRoleBindingWithStaleRoleRef removes a binding whose roleRef no
+// longer matches, such as the cluster-admin bindings created by earlier
+// versions of the operator. roleRef is immutable, so the binding has to be
+// recreated rather than updated.
+func (r *CAPIDeploymentReconciler) deleteClusterRoleBindingWithStaleRoleRef(ctx context.Context, binding *rbacv1.ClusterRoleBinding, clusterRoleName string) error {
+	existing := &rbacv1.ClusterRoleBinding{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: binding.Name}, existing); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if existing.RoleRef.Kind == "ClusterRole" && existing.RoleRef.Name == clusterRoleName {
+		return nil
+	}
+
+	return client.IgnoreNotFound(r.Client.Delete(ctx, existing))
+}
+
+// deleteClusterRoleBinding deletes a provider ClusterRoleBinding, but only if
+// it still binds a ServiceAccount in the given namespace. The legacy binding
+// names are shared cluster wide and may have been taken over by another
+// CAPIDeployment.
+func (r *CAPIDeploymentReconciler) deleteClusterRoleBinding(ctx context.Context, binding *rbacv1.ClusterRoleBinding, namespace string) error {
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: binding.Name}, binding); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	for _, subject := range binding.Subjects {
+		if subject.Kind == rbacv1.ServiceAccountKind && subject.Namespace == namespace {
+			return client.IgnoreNotFound(r.Client.Delete(ctx, binding))
+		}
+	}
+
+	return nil
+}
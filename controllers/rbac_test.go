@@ -0,0 +1,156 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestDeleteOrphanedClusterRoleBindings(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	capiDeployment := &operatorv1.CAPIDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-cluster-api", Name: "cluster"},
+	}
+	ownedBinding := func(name, namespace, owner string) *rbacv1.ClusterRoleBinding {
+		return &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					operatorv1.OwnerNamespaceLabel: namespace,
+					operatorv1.OwnerNameLabel:      owner,
+				},
+			},
+		}
+	}
+	unlabelled := &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "cluster-admin"}}
+
+	r := &CAPIDeploymentReconciler{
+		Client: newFakeClient(
+			capiDeployment,
+			&operatorv1.CAPIDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "cluster"}},
+			unlabelled,
+			ownedBinding("openshift-cluster-api-cluster-api-aws", capiDeployment.Namespace, capiDeployment.Name),
+			// Left behind by a provider this CAPIDeployment no longer runs.
+			ownedBinding("openshift-cluster-api-cluster-api-vsphere", capiDeployment.Namespace, capiDeployment.Name),
+			// Shared by all namespaces before bindings were named after them.
+			ownedBinding(capaManagerRBAC.ClusterRoleName, capiDeployment.Namespace, capiDeployment.Name),
+			// Owned by a CAPIDeployment in another namespace.
+			ownedBinding("other-cluster-api-aws", "other", "cluster"),
+			// Left behind by a CAPIDeployment that no longer exists.
+			ownedBinding("cluster-api-old", capiDeployment.Namespace, "renamed"),
+		),
+		Log:    ctrl.Log,
+		Scheme: testScheme,
+	}
+
+	g.Expect(r.deleteOrphanedClusterRoleBindings(ctx, capiDeployment, []managerRBAC{capiManagerRBAC, capaManagerRBAC})).To(Succeed())
+
+	for name, exists := range map[string]bool{
+		"openshift-cluster-api-cluster-api-aws":     true,
+		"other-cluster-api-aws":                     true,
+		"cluster-admin":                             true,
+		"openshift-cluster-api-cluster-api-vsphere": false,
+		capaManagerRBAC.ClusterRoleName:             false,
+		"cluster-api-old":                           false,
+	} {
+		err := r.Client.Get(ctx, types.NamespacedName{Name: name}, &rbacv1.ClusterRoleBinding{})
+		if exists {
+			g.Expect(err).NotTo(HaveOccurred(), name)
+		} else {
+			g.Expect(apierrors.IsNotFound(err)).To(BeTrue(), name)
+		}
+	}
+}
+
+func TestReconcileManagerRoleLeaderElection(t *testing.T) {
+	g := NewWithT(t)
+
+	role := &rbacv1.Role{}
+	g.Expect(reconcileManagerRole(role, capiManagerRBAC)).To(Succeed())
+	g.Expect(role.Rules).To(HaveLen(len(capiManagerRBAC.Rules) + len(leaderElectionRules)))
+	g.Expect(role.Rules[:len(capiManagerRBAC.Rules)]).To(Equal(capiManagerRBAC.Rules))
+	g.Expect(role.Rules).To(ContainElement(rbacv1.PolicyRule{
+		APIGroups: []string{"coordination.k8s.io"},
+		Resources: []string{"leases"},
+		Verbs:     allVerbs,
+	}))
+
+	// Rendering the role again does not grow it or touch the shared rules.
+	rulesLen := len(capiManagerRBAC.Rules)
+	g.Expect(reconcileManagerRole(role, capiManagerRBAC)).To(Succeed())
+	g.Expect(role.Rules).To(HaveLen(rulesLen + len(leaderElectionRules)))
+	g.Expect(capiManagerRBAC.Rules).To(HaveLen(rulesLen))
+}
+
+func TestManagerRBACRules(t *testing.T) {
+	rbacs := []managerRBAC{capiManagerRBAC}
+	for _, provider := range allInfrastructureProviders() {
+		rbacs = append(rbacs, provider.ManagerRBAC())
+	}
+
+	for _, rbac := range rbacs {
+		t.Run(rbac.ServiceAccountName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			role := managerRole(rbac, "openshift-cluster-api")
+			g.Expect(reconcileManagerRole(role, rbac)).To(Succeed())
+			g.Expect(role.Namespace).To(Equal("openshift-cluster-api"))
+			g.Expect(role.Rules).To(Equal(append(append([]rbacv1.PolicyRule{}, rbac.Rules...), leaderElectionRules...)))
+
+			roleBinding := managerRoleBinding(rbac, "openshift-cluster-api")
+			g.Expect(reconcileManagerRoleBinding(roleBinding, rbac, "openshift-cluster-api")).To(Succeed())
+			g.Expect(roleBinding.Subjects).To(Equal([]rbacv1.Subject{{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      rbac.ServiceAccountName,
+				Namespace: "openshift-cluster-api",
+			}}))
+			g.Expect(roleBinding.RoleRef).To(Equal(rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name}))
+
+			// Only the core manager follows references to any provider kind.
+			for _, rule := range rbac.Rules {
+				if rbac.ServiceAccountName != capiManagerRBAC.ServiceAccountName {
+					g.Expect(rule.Resources).NotTo(ContainElement("*"))
+				}
+				g.Expect(rule.Verbs).NotTo(ContainElement("*"))
+			}
+
+			if len(rbac.ClusterRules) == 0 {
+				return
+			}
+			g.Expect(rbac.ClusterRoleName).NotTo(BeEmpty())
+			clusterRole := managerClusterRole(rbac)
+			g.Expect(reconcileManagerClusterRole(clusterRole, rbac)).To(Succeed())
+			g.Expect(clusterRole.Rules).To(Equal(rbac.ClusterRules))
+			// Secrets are only ever readable in the CAPIDeployment namespace.
+			for _, rule := range clusterRole.Rules {
+				g.Expect(rule.Resources).NotTo(ContainElement("secrets"))
+				g.Expect(rule.Resources).NotTo(ContainElement("*"))
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/conditions"
+)
+
+// reconcileProvidersStatus records the readiness of every provider manager
+// Deployment and sets the ProvidersReady and Progressing conditions. It
+// returns true when all providers are available.
+func (r *CAPIDeploymentReconciler) reconcileProvidersStatus(ctx context.Context, capiDeployment *operatorv1.CAPIDeployment, deployments []*appsv1.Deployment) (bool, error) {
+	var notReady, progressing []string
+	for _, deployment := range deployments {
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}, deployment); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return false, err
+			}
+			setComponentStatus(capiDeployment, "Deployment", deployment.Name, false, "Deployment not found")
+			providerReadyReplicas.WithLabelValues(deployment.Namespace, deployment.Name).Set(0)
+			notReady = append(notReady, deployment.Name)
+			continue
+		}
+
+		providerReadyReplicas.WithLabelValues(deployment.Namespace, deployment.Name).Set(float64(deployment.Status.AvailableReplicas))
+		ready, rollingOut, message := deploymentStatus(deployment)
+		setComponentStatus(capiDeployment, "Deployment", deployment.Name, ready, message)
+		if !ready {
+			notReady = append(notReady, deployment.Name)
+		}
+		if rollingOut {
+			progressing = append(progressing, deployment.Name)
+		}
+	}
+
+	if len(progressing) > 0 {
+		conditions.Set(capiDeployment, &clusterv1.Condition{
+			Type:    operatorv1.ProgressingCondition,
+			Status:  corev1.ConditionTrue,
+			Reason:  operatorv1.RolloutInProgressReason,
+			Message: fmt.Sprintf("Rolling out %s", strings.Join(progressing, ", ")),
+		})
+	} else {
+		conditions.MarkFalse(capiDeployment, operatorv1.ProgressingCondition, operatorv1.AsExpectedReason, clusterv1.ConditionSeverityNone, "")
+	}
+
+	if len(notReady) > 0 {
+		conditions.MarkFalse(capiDeployment, operatorv1.ProvidersReadyCondition, operatorv1.ProvidersNotReadyReason, clusterv1.ConditionSeverityInfo, "Waiting for %s to become available", strings.Join(notReady, ", "))
+		return false, nil
+	}
+
+	conditions.MarkTrue(capiDeployment, operatorv1.ProvidersReadyCondition)
+	return true, nil
+}
+
+// deploymentStatus reports whether a Deployment has all of its desired
+// replicas updated and available, and whether a rollout is still under way.
+func deploymentStatus(deployment *appsv1.Deployment) (ready, progressing bool, message string) {
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+
+	status := deployment.Status
+	progressing = status.ObservedGeneration < deployment.Generation || status.UpdatedReplicas < desired
+	ready = !progressing && status.AvailableReplicas >= desired
+
+	return ready, progressing, fmt.Sprintf("%d/%d replicas available", status.AvailableReplicas, desired)
+}
+
+// setComponentStatus adds or updates the status entry of a single managed
+// object.
+func setComponentStatus(capiDeployment *operatorv1.CAPIDeployment, kind, name string, ready bool, message string) {
+	component := operatorv1.ComponentStatus{
+		Kind:    kind,
+		Name:    name,
+		Ready:   ready,
+		Message: message,
+	}
+
+	for i := range capiDeployment.Status.Components {
+		existing := &capiDeployment.Status.Components[i]
+		if existing.Kind == kind && existing.Name == name {
+			*existing = component
+			return
+		}
+	}
+	capiDeployment.Status.Components = append(capiDeployment.Status.Components, component)
+}
+
+// availableConditions are the conditions that must all be true for a
+// CAPIDeployment to be Available. The first one that is not true sets the
+// reason of the Available condition.
+var availableConditions = []clusterv1.ConditionType{
+	operatorv1.AdmittedCondition,
+	operatorv1.InfrastructureReadyCondition,
+	operatorv1.CredentialsReadyCondition,
+	operatorv1.ProvidersReadyCondition,
+}
+
+// setSummaryConditions derives the Degraded and Available conditions from
+// the result of a reconcile and the more specific conditions it recorded.
+func setSummaryConditions(capiDeployment *operatorv1.CAPIDeployment, reconcileErr error) {
+	if reconcileErr != nil {
+		conditions.Set(capiDeployment, &clusterv1.Condition{
+			Type:    operatorv1.DegradedCondition,
+			Status:  corev1.ConditionTrue,
+			Reason:  operatorv1.ReconcileFailedReason,
+			Message: reconcileErr.Error(),
+		})
+	} else {
+		conditions.MarkFalse(capiDeployment, operatorv1.DegradedCondition, operatorv1.AsExpectedReason, clusterv1.ConditionSeverityNone, "")
+	}
+
+	for _, t := range availableConditions {
+		if conditions.IsTrue(capiDeployment, t) {
+			continue
+		}
+
+		reason := conditions.GetReason(capiDeployment, t)
+		if reason == "" {
+			reason = operatorv1.ReconcileFailedReason
+		}
+		severity := clusterv1.ConditionSeverityError
+		if s := conditions.GetSeverity(capiDeployment, t); s != nil {
+			severity = *s
+		}
+		conditions.MarkFalse(capiDeployment, operatorv1.AvailableCondition, reason, severity, "%s", conditions.GetMessage(capiDeployment, t))
+		return
+	}
+
+	conditions.MarkTrue(capiDeployment, operatorv1.AvailableCondition)
+}
@@ -0,0 +1,183 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	operatorv1 "github.com/cloud-team-poc/openshift-cluster-api-operator/api/v1"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sutilspointer "k8s.io/utils/pointer"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/conditions"
+)
+
+func TestSetSummaryConditions(t *testing.T) {
+	allTrue := func(capiDeployment *operatorv1.CAPIDeployment) {
+		for _, conditionType := range availableConditions {
+			conditions.MarkTrue(capiDeployment, conditionType)
+		}
+	}
+
+	tests := []struct {
+		name              string
+		conditions        func(*operatorv1.CAPIDeployment)
+		reconcileErr      error
+		expectedAvailable clusterv1.Condition
+		expectedDegraded  clusterv1.Condition
+	}{
+		{
+			name:              "all ready",
+			conditions:        allTrue,
+			expectedAvailable: clusterv1.Condition{Type: operatorv1.AvailableCondition, Status: corev1.ConditionTrue},
+			expectedDegraded:  clusterv1.Condition{Type: operatorv1.DegradedCondition, Status: corev1.ConditionFalse, Reason: operatorv1.AsExpectedReason},
+		},
+		{
+			name: "providers not ready",
+			conditions: func(capiDeployment *operatorv1.CAPIDeployment) {
+				allTrue(capiDeployment)
+				conditions.MarkFalse(capiDeployment, operatorv1.ProvidersReadyCondition, operatorv1.ProvidersNotReadyReason, clusterv1.ConditionSeverityInfo, "Waiting for capi-controller-manager to become available")
+			},
+			expectedAvailable: clusterv1.Condition{
+				Type:     operatorv1.AvailableCondition,
+				Status:   corev1.ConditionFalse,
+				Severity: clusterv1.ConditionSeverityInfo,
+				Reason:   operatorv1.ProvidersNotReadyReason,
+				Message:  "Waiting for capi-controller-manager to become available",
+			},
+			expectedDegraded: clusterv1.Condition{Type: operatorv1.DegradedCondition, Status: corev1.ConditionFalse, Reason: operatorv1.AsExpectedReason},
+		},
+		{
+			name: "first condition that is not true wins",
+			conditions: func(capiDeployment *operatorv1.CAPIDeployment) {
+				allTrue(capiDeployment)
+				conditions.MarkFalse(capiDeployment, operatorv1.InfrastructureReadyCondition, operatorv1.PlatformNotSupportedReason, clusterv1.ConditionSeverityError, "platform BareMetal is not supported")
+				conditions.MarkFalse(capiDeployment, operatorv1.ProvidersReadyCondition, operatorv1.ProvidersNotReadyReason, clusterv1.ConditionSeverityInfo, "Waiting for capi-controller-manager to become available")
+			},
+			reconcileErr: errors.New("platform BareMetal is not supported"),
+			expectedAvailable: clusterv1.Condition{
+				Type:     operatorv1.AvailableCondition,
+				Status:   corev1.ConditionFalse,
+				Severity: clusterv1.ConditionSeverityError,
+				Reason:   operatorv1.PlatformNotSupportedReason,
+				Message:  "platform BareMetal is not supported",
+			},
+			expectedDegraded: clusterv1.Condition{
+				Type:    operatorv1.DegradedCondition,
+				Status:  corev1.ConditionTrue,
+				Reason:  operatorv1.ReconcileFailedReason,
+				Message: "platform BareMetal is not supported",
+			},
+		},
+		{
+			name:         "conditions not recorded",
+			conditions:   func(*operatorv1.CAPIDeployment) {},
+			reconcileErr: errors.New("failed to get infrastructure"),
+			expectedAvailable: clusterv1.Condition{
+				Type:     operatorv1.AvailableCondition,
+				Status:   corev1.ConditionFalse,
+				Severity: clusterv1.ConditionSeverityError,
+				Reason:   operatorv1.ReconcileFailedReason,
+			},
+			expectedDegraded: clusterv1.Condition{
+				Type:    operatorv1.DegradedCondition,
+				Status:  corev1.ConditionTrue,
+				Reason:  operatorv1.ReconcileFailedReason,
+				Message: "failed to get infrastructure",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			capiDeployment := &operatorv1.CAPIDeployment{}
+			tt.conditions(capiDeployment)
+			setSummaryConditions(capiDeployment, tt.reconcileErr)
+
+			for _, expected := range []clusterv1.Condition{tt.expectedAvailable, tt.expectedDegraded} {
+				got := conditions.Get(capiDeployment, expected.Type)
+				g.Expect(got).NotTo(BeNil())
+				got.LastTransitionTime = expected.LastTransitionTime
+				g.Expect(*got).To(Equal(expected))
+			}
+		})
+	}
+}
+
+func TestDeploymentStatus(t *testing.T) {
+	tests := []struct {
+		name                string
+		replicas            *int32
+		generation          int64
+		status              appsv1.DeploymentStatus
+		expectedReady       bool
+		expectedProgressing bool
+		expectedMessage     string
+	}{
+		{
+			name:            "available",
+			replicas:        k8sutilspointer.Int32Ptr(2),
+			generation:      1,
+			status:          appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 2, AvailableReplicas: 2},
+			expectedReady:   true,
+			expectedMessage: "2/2 replicas available",
+		},
+		{
+			name:                "spec not observed yet",
+			replicas:            k8sutilspointer.Int32Ptr(1),
+			generation:          2,
+			status:              appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+			expectedProgressing: true,
+			expectedMessage:     "1/1 replicas available",
+		},
+		{
+			name:                "rolling out",
+			replicas:            k8sutilspointer.Int32Ptr(2),
+			generation:          1,
+			status:              appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 1, AvailableReplicas: 2},
+			expectedProgressing: true,
+			expectedMessage:     "2/2 replicas available",
+		},
+		{
+			name:            "unavailable with replicas defaulted",
+			generation:      1,
+			status:          appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 1},
+			expectedMessage: "0/1 replicas available",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			deployment := &appsv1.Deployment{}
+			deployment.Generation = tt.generation
+			deployment.Spec.Replicas = tt.replicas
+			deployment.Status = tt.status
+
+			ready, progressing, message := deploymentStatus(deployment)
+			g.Expect(ready).To(Equal(tt.expectedReady))
+			g.Expect(progressing).To(Equal(tt.expectedProgressing))
+			g.Expect(message).To(Equal(tt.expectedMessage))
+		})
+	}
+}
@@ -22,9 +22,14 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	configv1 "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/api/v1alpha3"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -41,6 +46,25 @@ var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
 
+// testScheme holds every API the controllers read or write, for the fake
+// clients of the unit tests.
+var testScheme = newTestScheme()
+
+func newTestScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = capiv1.AddToScheme(s)
+	_ = configv1.AddToScheme(s)
+	_ = infrav1.AddToScheme(s)
+	_ = clusterv1.AddToScheme(s)
+	return s
+}
+
+// newFakeClient returns a fake client serving objs.
+func newFakeClient(objs ...runtime.Object) client.Client {
+	return fake.NewFakeClientWithScheme(testScheme, objs...)
+}
+
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
 
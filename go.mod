@@ -3,10 +3,13 @@ module github.com/cloud-team-poc/openshift-cluster-api-operator
 go 1.13
 
 require (
+	github.com/aws/aws-sdk-go v1.36.26
+	github.com/docker/distribution v2.7.1+incompatible
 	github.com/go-logr/logr v0.1.0
 	github.com/onsi/ginkgo v1.14.1
 	github.com/onsi/gomega v1.10.2
 	github.com/openshift/api v0.0.0-20200618202633-7192180f496a // indirect
+	github.com/prometheus/client_golang v1.7.1
 	k8s.io/api v0.17.9 // indirect
 	k8s.io/apimachinery v0.17.9
 	k8s.io/client-go v0.17.9
@@ -14,4 +17,5 @@ require (
 	sigs.k8s.io/cluster-api v0.3.16 // indirect
 	sigs.k8s.io/cluster-api-provider-aws v0.6.5
 	sigs.k8s.io/controller-runtime v0.5.14
+	sigs.k8s.io/yaml v1.2.0
 )
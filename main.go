@@ -76,21 +76,45 @@ func main() {
 	}
 
 	if err = (&controllers.AWSClusterReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("AWSCluster"),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("AWSCluster"),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("awscluster-controller"),
+		APIReader: mgr.GetAPIReader(),
+
+		OperatorNamespace:      os.Getenv("OPERATOR_NAMESPACE"),
+		OperatorServiceAccount: os.Getenv("OPERATOR_SERVICE_ACCOUNT"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AWSCluster")
 		os.Exit(1)
 	}
 	if err = (&controllers.CAPIDeploymentReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("CAPIDeployment"),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("CAPIDeployment"),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("capideployment-controller"),
+		APIReader: mgr.GetAPIReader(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CAPIDeployment")
 		os.Exit(1)
 	}
+	if err = (&controllers.ClusterOperatorReconciler{
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName("ClusterOperator"),
+		Scheme:         mgr.GetScheme(),
+		ReleaseVersion: os.Getenv("RELEASE_VERSION"),
+
+		OperatorNamespace: os.Getenv("OPERATOR_NAMESPACE"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterOperator")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&capiv1.CAPIDeployment{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "CAPIDeployment")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")